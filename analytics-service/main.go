@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"strings"
@@ -27,19 +28,72 @@ var (
 	kafkaReader *kafka.Reader
 	ctx         = context.Background()
 	port        = getEnv("PORT", "3003")
+
+	// Имя домена по умолчанию (хост PUBLIC_BASE_URL): в событиях его ссылки записаны без domain
+	defaultDomainName = publicHost(getEnv("PUBLIC_BASE_URL", "http://localhost:3002"))
 )
 
 type ClickEvent struct {
 	ShortCode string    `bson:"shortCode" json:"shortCode"`
+	Domain    string    `bson:"domain,omitempty" json:"domain,omitempty"`
 	Timestamp time.Time `bson:"timestamp" json:"timestamp"`
 	UserAgent string    `bson:"userAgent" json:"userAgent"`
 	IP        string    `bson:"ip" json:"ip"`
+	// Переход исчерпал лимит maxClicks ссылки
+	Exhausted bool `bson:"exhausted,omitempty" json:"exhausted,omitempty"`
+	// Устройство и страна посетителя и ветка правил ссылки, выбранная redirect-service
+	Platform string `bson:"platform,omitempty" json:"platform,omitempty"`
+	Device   string `bson:"device,omitempty" json:"device,omitempty"`
+	Country  string `bson:"country,omitempty" json:"country,omitempty"`
+	Route    string `bson:"route,omitempty" json:"route,omitempty"`
+	// Вариант A/B-теста ссылки
+	Variant string `bson:"variant,omitempty" json:"variant,omitempty"`
+	// Метки кампании utm_* адреса перехода
+	UTMSource   string `bson:"utmSource,omitempty" json:"utmSource,omitempty"`
+	UTMMedium   string `bson:"utmMedium,omitempty" json:"utmMedium,omitempty"`
+	UTMCampaign string `bson:"utmCampaign,omitempty" json:"utmCampaign,omitempty"`
+	UTMTerm     string `bson:"utmTerm,omitempty" json:"utmTerm,omitempty"`
+	UTMContent  string `bson:"utmContent,omitempty" json:"utmContent,omitempty"`
+}
+
+// Поля события с метками кампании по именам в разбивке StatsResponse.UTM
+var utmFields = []struct{ name, field string }{
+	{"source", "utmSource"},
+	{"medium", "utmMedium"},
+	{"campaign", "utmCampaign"},
+	{"term", "utmTerm"},
+	{"content", "utmContent"},
 }
 
 type StatsResponse struct {
 	ShortCode   string     `json:"shortCode"`
+	Domain      string     `json:"domain,omitempty"`
 	TotalClicks int64      `json:"totalClicks"`
 	LastClick   *time.Time `json:"lastClick,omitempty"`
+	// Когда ссылка исчерпала лимит переходов
+	ExhaustedAt *time.Time `json:"exhaustedAt,omitempty"`
+	// Переходы по веткам правил маршрутизации, вариантам A/B-теста, платформам и странам;
+	// только для одной ссылки
+	Routes    map[string]int64 `json:"routes,omitempty"`
+	Variants  map[string]int64 `json:"variants,omitempty"`
+	Platforms map[string]int64 `json:"platforms,omitempty"`
+	Countries map[string]int64 `json:"countries,omitempty"`
+	// Переходы по значениям меток кампании: {"source": {"newsletter": 10}, "campaign": {...}}
+	UTM map[string]map[string]int64 `json:"utm,omitempty"`
+}
+
+// UTMStatsResponse - переходы по всем ссылкам в разбивке по значениям одной метки кампании
+type UTMStatsResponse struct {
+	Field  string          `json:"field"`
+	Values []UTMValueStats `json:"values"`
+	Total  int             `json:"total"`
+}
+
+type UTMValueStats struct {
+	Value  string `json:"value"`
+	Clicks int64  `json:"clicks"`
+	// Сколько разных ссылок принесли переходы с этим значением
+	Links int `json:"links"`
 }
 
 type AllStatsResponse struct {
@@ -86,6 +140,7 @@ func main() {
 	router.HandleFunc("/health", healthHandler).Methods("GET")
 	router.HandleFunc("/stats/{shortCode}", statsHandler).Methods("GET")
 	router.HandleFunc("/stats", allStatsHandler).Methods("GET")
+	router.HandleFunc("/stats/utm/{field}", utmStatsHandler).Methods("GET")
 
 	handler := cors.New(cors.Options{
 		AllowedOrigins: []string{"*"},
@@ -140,9 +195,9 @@ func initMongoDB() {
 	mongoClient = client
 	collection = client.Database("analytics").Collection("clicks")
 
-	// Создание индекса для shortCode
+	// Создание индекса для ссылки: go.brand-a.com/x и go.brand-b.com/x - разные ссылки
 	indexModel := mongo.IndexModel{
-		Keys: bson.D{{Key: "shortCode", Value: 1}},
+		Keys: bson.D{{Key: "shortCode", Value: 1}, {Key: "domain", Value: 1}},
 	}
 	_, err = collection.Indexes().CreateOne(ctx, indexModel)
 	if err != nil {
@@ -212,11 +267,56 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, response)
 }
 
+// publicHost возвращает хост базового URL в нижнем регистре
+func publicHost(baseURL string) string {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// requestScope возвращает пространство кодов домена из параметра ?domain=; пустое - домен по умолчанию
+func requestScope(r *http.Request) string {
+	name := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("domain")))
+	if name == defaultDomainName {
+		return ""
+	}
+	return name
+}
+
+// scopeFilter отбирает события домена; у домена по умолчанию поля domain в событиях нет
+func scopeFilter(scope string) bson.M {
+	if scope == "" {
+		return bson.M{"domain": bson.M{"$in": bson.A{nil, ""}}}
+	}
+	return bson.M{"domain": scope}
+}
+
+// linkFilter отбирает события одной ссылки: код вместе с доменом
+func linkFilter(scope, shortCode string) bson.M {
+	filter := scopeFilter(scope)
+	filter["shortCode"] = shortCode
+	return filter
+}
+
+// domainName возвращает имя домена для ответа по пространству кодов
+func domainName(scope string) string {
+	if scope == "" {
+		return defaultDomainName
+	}
+	return scope
+}
+
+// statsHandler возвращает статистику ссылки: GET /stats/{shortCode}?domain=go.brand-a.com,
+// без domain - ссылки домена по умолчанию
 func statsHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	shortCode := vars["shortCode"]
+	scope := requestScope(r)
+	filter := linkFilter(scope, shortCode)
 
-	count, err := collection.CountDocuments(ctx, bson.M{"shortCode": shortCode})
+	count, err := collection.CountDocuments(ctx, filter)
 	if err != nil {
 		log.Printf("[Analytics Service] Failed to count documents: %v\n", err)
 		respondError(w, http.StatusInternalServerError, "Failed to get statistics")
@@ -225,26 +325,112 @@ func statsHandler(w http.ResponseWriter, r *http.Request) {
 
 	response := StatsResponse{
 		ShortCode:   shortCode,
+		Domain:      domainName(scope),
 		TotalClicks: count,
 	}
 
 	// Получение последнего клика
 	opts := options.FindOne().SetSort(bson.D{{Key: "timestamp", Value: -1}})
 	var lastClick ClickEvent
-	err = collection.FindOne(ctx, bson.M{"shortCode": shortCode}, opts).Decode(&lastClick)
+	err = collection.FindOne(ctx, filter, opts).Decode(&lastClick)
 	if err == nil {
 		response.LastClick = &lastClick.Timestamp
 	}
 
+	// Событие исчерпания лимита переходов
+	var exhaustion ClickEvent
+	exhausted := linkFilter(scope, shortCode)
+	exhausted["exhausted"] = true
+	err = collection.FindOne(ctx, exhausted).Decode(&exhaustion)
+	if err == nil {
+		response.ExhaustedAt = &exhaustion.Timestamp
+	}
+
+	if response.Routes, err = countByField(filter, "route"); err != nil {
+		log.Printf("[Analytics Service] Failed to count routes: %v\n", err)
+	}
+	if response.Variants, err = countByField(filter, "variant"); err != nil {
+		log.Printf("[Analytics Service] Failed to count variants: %v\n", err)
+	}
+	if response.Platforms, err = countByField(filter, "platform"); err != nil {
+		log.Printf("[Analytics Service] Failed to count platforms: %v\n", err)
+	}
+	if response.Countries, err = countByField(filter, "country"); err != nil {
+		log.Printf("[Analytics Service] Failed to count countries: %v\n", err)
+	}
+	for _, utm := range utmFields {
+		counts, err := countByField(filter, utm.field)
+		if err != nil {
+			log.Printf("[Analytics Service] Failed to count %s: %v\n", utm.field, err)
+			continue
+		}
+		if counts != nil {
+			if response.UTM == nil {
+				response.UTM = map[string]map[string]int64{}
+			}
+			response.UTM[utm.name] = counts
+		}
+	}
+
 	respondJSON(w, http.StatusOK, response)
 }
 
+// countByField считает переходы ссылки (см. linkFilter) по значениям поля события; события без этого поля не учитываются
+func countByField(link bson.M, field string) (map[string]int64, error) {
+	match := bson.M{field: bson.M{"$exists": true, "$ne": ""}}
+	for key, value := range link {
+		match[key] = value
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$" + field},
+			{Key: "clicks", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}},
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var counts map[string]int64
+	for cursor.Next(ctx) {
+		var result struct {
+			ID     string `bson:"_id"`
+			Clicks int64  `bson:"clicks"`
+		}
+		if err := cursor.Decode(&result); err != nil {
+			return nil, err
+		}
+		if counts == nil {
+			counts = map[string]int64{}
+		}
+		counts[result.ID] = result.Clicks
+	}
+	return counts, cursor.Err()
+}
+
+// allStatsHandler возвращает статистику всех ссылок по парам (домен, код); ?domain= оставляет один домен
 func allStatsHandler(w http.ResponseWriter, r *http.Request) {
+	match := bson.M{}
+	if r.URL.Query().Has("domain") {
+		match = scopeFilter(requestScope(r))
+	}
+
 	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
 		{{Key: "$group", Value: bson.D{
-			{Key: "_id", Value: "$shortCode"},
+			{Key: "_id", Value: bson.D{
+				{Key: "domain", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$domain", ""}}}},
+				{Key: "shortCode", Value: "$shortCode"},
+			}},
 			{Key: "totalClicks", Value: bson.D{{Key: "$sum", Value: 1}}},
 			{Key: "lastClick", Value: bson.D{{Key: "$max", Value: "$timestamp"}}},
+			{Key: "exhaustedAt", Value: bson.D{{Key: "$max", Value: bson.D{
+				{Key: "$cond", Value: bson.A{"$exhausted", "$timestamp", nil}},
+			}}}},
 		}}},
 		{{Key: "$sort", Value: bson.D{{Key: "totalClicks", Value: -1}}}},
 	}
@@ -260,9 +446,13 @@ func allStatsHandler(w http.ResponseWriter, r *http.Request) {
 	var stats []StatsResponse
 	for cursor.Next(ctx) {
 		var result struct {
-			ID          string    `bson:"_id"`
-			TotalClicks int64     `bson:"totalClicks"`
-			LastClick   time.Time `bson:"lastClick"`
+			ID struct {
+				Domain    string `bson:"domain"`
+				ShortCode string `bson:"shortCode"`
+			} `bson:"_id"`
+			TotalClicks int64      `bson:"totalClicks"`
+			LastClick   time.Time  `bson:"lastClick"`
+			ExhaustedAt *time.Time `bson:"exhaustedAt"`
 		}
 		if err := cursor.Decode(&result); err != nil {
 			log.Printf("[Analytics Service] Failed to decode result: %v\n", err)
@@ -270,9 +460,11 @@ func allStatsHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		stats = append(stats, StatsResponse{
-			ShortCode:   result.ID,
+			ShortCode:   result.ID.ShortCode,
+			Domain:      domainName(result.ID.Domain),
 			TotalClicks: result.TotalClicks,
 			LastClick:   &result.LastClick,
+			ExhaustedAt: result.ExhaustedAt,
 		})
 	}
 
@@ -288,6 +480,70 @@ func allStatsHandler(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, response)
 }
 
+// utmStatsHandler считает переходы по всем ссылкам по значениям метки: GET /stats/utm/campaign?source=newsletter.
+// Параметры запроса source, medium, campaign, term и content отбирают переходы с заданными значениями меток.
+func utmStatsHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["field"]
+	var field string
+	filter := bson.M{}
+	for _, utm := range utmFields {
+		if utm.name == name {
+			field = utm.field
+		}
+		if value := r.URL.Query().Get(utm.name); value != "" {
+			filter[utm.field] = value
+		}
+	}
+	if field == "" {
+		respondError(w, http.StatusNotFound, "Unknown UTM field, expected source, medium, campaign, term or content")
+		return
+	}
+	if _, ok := filter[field]; !ok {
+		filter[field] = bson.M{"$exists": true, "$ne": ""}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$" + field},
+			{Key: "clicks", Value: bson.D{{Key: "$sum", Value: 1}}},
+			{Key: "links", Value: bson.D{{Key: "$addToSet", Value: bson.D{
+				{Key: "domain", Value: "$domain"},
+				{Key: "shortCode", Value: "$shortCode"},
+			}}}},
+		}}},
+		{{Key: "$project", Value: bson.D{
+			{Key: "clicks", Value: 1},
+			{Key: "links", Value: bson.D{{Key: "$size", Value: "$links"}}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "clicks", Value: -1}, {Key: "_id", Value: 1}}}},
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		log.Printf("[Analytics Service] Failed to aggregate UTM stats: %v\n", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get statistics")
+		return
+	}
+	defer cursor.Close(ctx)
+
+	values := []UTMValueStats{}
+	for cursor.Next(ctx) {
+		var result struct {
+			ID     string `bson:"_id"`
+			Clicks int64  `bson:"clicks"`
+			Links  int    `bson:"links"`
+		}
+		if err := cursor.Decode(&result); err != nil {
+			log.Printf("[Analytics Service] Failed to decode result: %v\n", err)
+			continue
+		}
+		values = append(values, UTMValueStats{Value: result.ID, Clicks: result.Clicks, Links: result.Links})
+	}
+
+	respondJSON(w, http.StatusOK, UTMStatsResponse{Field: name, Values: values, Total: len(values)})
+}
+
 func respondJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
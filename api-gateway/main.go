@@ -8,6 +8,7 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"syscall"
@@ -71,6 +72,15 @@ func main() {
 
 	// API routes
 	router.HandleFunc("/api/shorten", shortenHandler).Methods("POST")
+	router.HandleFunc("/api/shorten/batch", batchShortenHandler).Methods("POST")
+	router.HandleFunc("/api/links", listLinksHandler).Methods("GET")
+	router.HandleFunc("/api/links/import", importLinksHandler).Methods("POST")
+	router.HandleFunc("/api/links/export", exportLinksHandler).Methods("GET")
+	router.HandleFunc("/api/links/{code}", linkHandler).Methods("GET", "PATCH", "DELETE")
+	router.HandleFunc("/api/links/{code}/route", routePreviewHandler).Methods("GET")
+	router.HandleFunc("/api/utm-presets", utmPresetsHandler).Methods("GET")
+	router.HandleFunc("/api/utm-presets/{name}", utmPresetHandler).Methods("GET", "PUT", "DELETE")
+	router.HandleFunc("/api/stats/utm/{field}", utmStatsHandler).Methods("GET")
 	router.HandleFunc("/api/stats/{shortCode}", statsHandler).Methods("GET")
 	router.HandleFunc("/api/stats", allStatsHandler).Methods("GET")
 	router.HandleFunc("/api/info", infoHandler).Methods("GET")
@@ -78,7 +88,7 @@ func main() {
 	// CORS
 	handler := cors.New(cors.Options{
 		AllowedOrigins: []string{"*"},
-		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders: []string{"*"},
 	}).Handler(router)
 
@@ -150,51 +160,126 @@ func shortenHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(respBody)
 }
 
-func statsHandler(w http.ResponseWriter, r *http.Request) {
+func listLinksHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[API Gateway] Proxying list links request to %s\n", shortenerServiceURL)
+
+	proxyRequest(w, r, shortenerServiceURL+"/links", "shortener service")
+}
+
+func linkHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	shortCode := vars["shortCode"]
+	code := vars["code"]
 
-	log.Printf("[API Gateway] Proxying stats request for %s to %s\n", shortCode, analyticsServiceURL)
+	log.Printf("[API Gateway] Proxying %s link request for %s to %s\n", r.Method, code, shortenerServiceURL)
 
-	resp, err := http.Get(fmt.Sprintf("%s/stats/%s", analyticsServiceURL, shortCode))
-	if err != nil {
-		log.Printf("[API Gateway] Error proxying to analytics-service: %v\n", err)
-		respondError(w, http.StatusServiceUnavailable, "Failed to connect to analytics service")
-		return
-	}
-	defer resp.Body.Close()
+	target := fmt.Sprintf("%s/links/%s", shortenerServiceURL, url.PathEscape(code))
+	proxyRequest(w, r, target, "shortener service")
+}
 
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to read response")
-		return
-	}
+func routePreviewHandler(w http.ResponseWriter, r *http.Request) {
+	code := mux.Vars(r)["code"]
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(resp.StatusCode)
-	w.Write(respBody)
+	log.Printf("[API Gateway] Proxying route preview request for %s to %s\n", code, shortenerServiceURL)
+
+	target := fmt.Sprintf("%s/links/%s/route", shortenerServiceURL, url.PathEscape(code))
+	proxyRequest(w, r, target, "shortener service")
 }
 
-func allStatsHandler(w http.ResponseWriter, r *http.Request) {
-	log.Printf("[API Gateway] Proxying all stats request to %s\n", analyticsServiceURL)
+func utmPresetsHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[API Gateway] Proxying UTM presets request to %s\n", shortenerServiceURL)
+
+	proxyRequest(w, r, shortenerServiceURL+"/utm-presets", "shortener service")
+}
+
+func utmPresetHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	log.Printf("[API Gateway] Proxying %s UTM preset request for %s to %s\n", r.Method, name, shortenerServiceURL)
 
-	resp, err := http.Get(analyticsServiceURL + "/stats")
+	target := fmt.Sprintf("%s/utm-presets/%s", shortenerServiceURL, url.PathEscape(name))
+	proxyRequest(w, r, target, "shortener service")
+}
+
+// proxyRequest пересылает запрос в сервис с сохранением метода, тела и query-параметров
+func proxyRequest(w http.ResponseWriter, r *http.Request, target, serviceName string) {
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, target, r.Body)
 	if err != nil {
-		log.Printf("[API Gateway] Error proxying to analytics-service: %v\n", err)
-		respondError(w, http.StatusServiceUnavailable, "Failed to connect to analytics service")
+		respondError(w, http.StatusInternalServerError, "Failed to build request")
 		return
 	}
-	defer resp.Body.Close()
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	req.Header.Set("Content-Type", contentType)
 
-	respBody, err := io.ReadAll(resp.Body)
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to read response")
+		log.Printf("[API Gateway] Error proxying to %s: %v\n", serviceName, err)
+		respondError(w, http.StatusServiceUnavailable, "Failed to connect to "+serviceName)
 		return
 	}
+	defer resp.Body.Close()
 
-	w.Header().Set("Content-Type", "application/json")
+	for _, header := range []string{"Content-Type", "Content-Disposition"} {
+		if value := resp.Header.Get(header); value != "" {
+			w.Header().Set(header, value)
+		}
+	}
 	w.WriteHeader(resp.StatusCode)
-	w.Write(respBody)
+
+	// Ответ копируется потоком, чтобы не держать в памяти крупные выгрузки
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		log.Printf("[API Gateway] Error streaming response from %s: %v\n", serviceName, err)
+	}
+}
+
+func importLinksHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[API Gateway] Proxying links import request to %s\n", shortenerServiceURL)
+
+	proxyRequest(w, r, shortenerServiceURL+"/links/import", "shortener service")
+}
+
+func exportLinksHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[API Gateway] Proxying links export request to %s\n", shortenerServiceURL)
+
+	proxyRequest(w, r, shortenerServiceURL+"/links/export", "shortener service")
+}
+
+func batchShortenHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[API Gateway] Proxying batch shorten request to %s\n", shortenerServiceURL)
+
+	proxyRequest(w, r, shortenerServiceURL+"/shorten/batch", "shortener service")
+}
+
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	shortCode := vars["shortCode"]
+
+	log.Printf("[API Gateway] Proxying stats request for %s to %s\n", shortCode, analyticsServiceURL)
+
+	// Параметр domain выбирает ссылку брендированного домена
+	target := fmt.Sprintf("%s/stats/%s", analyticsServiceURL, url.PathEscape(shortCode))
+	proxyRequest(w, r, target, "analytics service")
+}
+
+func utmStatsHandler(w http.ResponseWriter, r *http.Request) {
+	field := mux.Vars(r)["field"]
+
+	log.Printf("[API Gateway] Proxying UTM stats request for %s to %s\n", field, analyticsServiceURL)
+
+	target := fmt.Sprintf("%s/stats/utm/%s", analyticsServiceURL, url.PathEscape(field))
+	proxyRequest(w, r, target, "analytics service")
+}
+
+func allStatsHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[API Gateway] Proxying all stats request to %s\n", analyticsServiceURL)
+
+	proxyRequest(w, r, analyticsServiceURL+"/stats", "analytics service")
 }
 
 func infoHandler(w http.ResponseWriter, r *http.Request) {
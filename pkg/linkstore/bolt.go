@@ -0,0 +1,544 @@
+package linkstore
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	linksBucket    = []byte("links")
+	indexesBucket  = []byte("indexes")
+	refsBucket     = []byte("refs")
+	countersBucket = []byte("counters")
+)
+
+// BoltOptions - параметры файлового хранилища
+type BoltOptions struct {
+	// Shared открывает файл только на время операции. bbolt блокирует файл целиком,
+	// поэтому без этого режима второй процесс (например, redirect-service) не сможет его открыть.
+	// Цена режима - открытие файла (блокировка, mmap, чтение метаданных) на каждую операцию,
+	// включая каждый переход. Чтения открывают файл только для чтения под общей блокировкой
+	// и идут параллельно, записи берут исключительную блокировку и выполняются по одной.
+	Shared   bool
+	ReadOnly bool
+	// Сколько ждать блокировку файла, занятого другим процессом
+	LockTimeout time.Duration
+}
+
+// Сколько ссылок Scan читает за одну транзакцию
+const scanBatch = 500
+
+// BoltStore хранит ссылки во встраиваемой базе bbolt: записи - в бакете links,
+// каждый индекс - во вложенном бакете indexes/<name> (ключи bbolt упорядочены лексикографически).
+type BoltStore struct {
+	path string
+	opts BoltOptions
+	db   *bolt.DB // открыт постоянно, если не включён режим Shared
+}
+
+// boltEntry - значение в бакете links
+type boltEntry struct {
+	Record   json.RawMessage `json:"record"`
+	DeleteAt *time.Time      `json:"deleteAt,omitempty"`
+}
+
+func OpenBolt(path string, opts BoltOptions) (*BoltStore, error) {
+	if path == "" {
+		return nil, errors.New("bolt store path is empty")
+	}
+	if opts.LockTimeout == 0 {
+		opts.LockTimeout = 5 * time.Second
+	}
+	s := &BoltStore{path: path, opts: opts}
+
+	if !opts.ReadOnly {
+		err := s.update(func(tx *bolt.Tx) error {
+			for _, name := range [][]byte{linksBucket, indexesBucket, refsBucket, countersBucket} {
+				if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !opts.Shared {
+		db, err := s.open(opts.ReadOnly)
+		if err != nil {
+			return nil, err
+		}
+		s.db = db
+	}
+	return s, nil
+}
+
+func (s *BoltStore) open(readOnly bool) (*bolt.DB, error) {
+	return bolt.Open(s.path, 0600, &bolt.Options{Timeout: s.opts.LockTimeout, ReadOnly: readOnly})
+}
+
+// withDB выполняет fn с открытой базой; в режиме Shared файл открывается на время вызова,
+// для чтения - под общей блокировкой, чтобы читатели не ждали друг друга
+func (s *BoltStore) withDB(readOnly bool, fn func(db *bolt.DB) error) error {
+	if s.db != nil {
+		return fn(s.db)
+	}
+	db, err := s.open(s.opts.ReadOnly || readOnly)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return fn(db)
+}
+
+func (s *BoltStore) view(fn func(tx *bolt.Tx) error) error {
+	return s.withDB(true, func(db *bolt.DB) error {
+		return db.View(fn)
+	})
+}
+
+func (s *BoltStore) update(fn func(tx *bolt.Tx) error) error {
+	return s.withDB(false, func(db *bolt.DB) error {
+		return db.Update(fn)
+	})
+}
+
+// getRecord читает живую запись из транзакции; nil - ссылки нет, срок хранения истёк или запись нечитаема
+func getRecord(tx *bolt.Tx, id string, now time.Time) *Record {
+	record, _ := readRecord(tx, id, now)
+	return record
+}
+
+// readRecord читает живую запись или возвращает ошибку разбора; nil без ошибки - записи нет
+func readRecord(tx *bolt.Tx, id string, now time.Time) (*Record, error) {
+	links := tx.Bucket(linksBucket)
+	if links == nil {
+		return nil, nil
+	}
+	raw := links.Get([]byte(id))
+	if raw == nil {
+		return nil, nil
+	}
+
+	var entry boltEntry
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		return nil, err
+	}
+	if entry.DeleteAt != nil && !now.Before(*entry.DeleteAt) {
+		return nil, nil
+	}
+	return decodeRecord(string(entry.Record), nil, nil)
+}
+
+// putRecord записывает ссылку и добавляет её в индексы
+func putRecord(tx *bolt.Tx, id string, record *Record, ttl time.Duration) error {
+	value, err := encodeRecord(record)
+	if err != nil {
+		return err
+	}
+	entry := boltEntry{Record: json.RawMessage(value)}
+	if at := deadline(ttl); !at.IsZero() {
+		entry.DeleteAt = &at
+	}
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(linksBucket).Put([]byte(id), raw); err != nil {
+		return err
+	}
+
+	indexes, member := indexesFor(id, record)
+	for _, index := range indexes {
+		bucket, err := tx.Bucket(indexesBucket).CreateBucketIfNotExists([]byte(index))
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put([]byte(member), []byte{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeRecord удаляет ссылку (в том числе с истёкшим сроком хранения) вместе с элементами индексов
+func removeRecord(tx *bolt.Tx, id string) error {
+	links := tx.Bucket(linksBucket)
+	raw := links.Get([]byte(id))
+	if raw == nil {
+		return nil
+	}
+
+	var entry boltEntry
+	if err := json.Unmarshal(raw, &entry); err == nil {
+		if record, err := decodeRecord(string(entry.Record), nil, nil); err == nil {
+			indexes, member := indexesFor(id, record)
+			for _, index := range indexes {
+				if err := deleteMember(tx, index, member); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return links.Delete([]byte(id))
+}
+
+func deleteMember(tx *bolt.Tx, index, member string) error {
+	bucket := tx.Bucket(indexesBucket).Bucket([]byte(index))
+	if bucket == nil {
+		return nil
+	}
+	return bucket.Delete([]byte(member))
+}
+
+func (s *BoltStore) Get(ctx context.Context, id string) (*Record, error) {
+	var record *Record
+	err := s.view(func(tx *bolt.Tx) error {
+		var err error
+		if record, err = readRecord(tx, id, time.Now()); err != nil {
+			return &UnreadableError{IDs: []string{id}, Err: err}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, ErrNotFound
+	}
+	return record, nil
+}
+
+func (s *BoltStore) GetMany(ctx context.Context, ids []string) ([]*Record, error) {
+	records := make([]*Record, len(ids))
+	var unreadable *UnreadableError
+	err := s.view(func(tx *bolt.Tx) error {
+		now := time.Now()
+		for i, id := range ids {
+			record, err := readRecord(tx, id, now)
+			if err != nil {
+				addUnreadable(&unreadable, id, err)
+				continue
+			}
+			records[i] = record
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, unreadableErr(unreadable)
+}
+
+func (s *BoltStore) Create(ctx context.Context, id string, record *Record, ttl time.Duration) (bool, error) {
+	created, err := s.CreateMany(ctx, []Entry{{ID: id, Record: record, TTL: ttl}})
+	if err != nil {
+		return false, err
+	}
+	return created[0], nil
+}
+
+func (s *BoltStore) CreateMany(ctx context.Context, entries []Entry) ([]bool, error) {
+	created := make([]bool, len(entries))
+	err := s.update(func(tx *bolt.Tx) error {
+		now := time.Now()
+		for i, entry := range entries {
+			// Нечитаемая запись тоже занимает id
+			if record, err := readRecord(tx, entry.ID, now); record != nil || err != nil {
+				continue
+			}
+			if err := removeRecord(tx, entry.ID); err != nil {
+				return err
+			}
+			if err := putRecord(tx, entry.ID, entry.Record, entry.TTL); err != nil {
+				return err
+			}
+			// Счётчик переходов мог остаться от прежней ссылки с тем же id
+			if err := tx.Bucket(countersBucket).Delete([]byte(clicksKey(entry.ID))); err != nil {
+				return err
+			}
+			created[i] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func (s *BoltStore) Update(ctx context.Context, id string, record *Record, ttl time.Duration) error {
+	return s.write(id, record, ttl, true)
+}
+
+func (s *BoltStore) Put(ctx context.Context, id string, record *Record, ttl time.Duration) error {
+	return s.write(id, record, ttl, false)
+}
+
+func (s *BoltStore) write(id string, record *Record, ttl time.Duration, mustExist bool) error {
+	return s.update(func(tx *bolt.Tx) error {
+		if mustExist && getRecord(tx, id, time.Now()) == nil {
+			return ErrNotFound
+		}
+		if !mustExist {
+			if err := tx.Bucket(countersBucket).Delete([]byte(clicksKey(id))); err != nil {
+				return err
+			}
+		}
+		if err := removeRecord(tx, id); err != nil {
+			return err
+		}
+		return putRecord(tx, id, record, ttl)
+	})
+}
+
+func (s *BoltStore) Delete(ctx context.Context, id string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		if getRecord(tx, id, time.Now()) == nil {
+			return ErrNotFound
+		}
+		if err := tx.Bucket(countersBucket).Delete([]byte(clicksKey(id))); err != nil {
+			return err
+		}
+		return removeRecord(tx, id)
+	})
+}
+
+func (s *BoltStore) Range(ctx context.Context, index, cursor string, ascending bool, limit int) ([]string, error) {
+	var page []string
+	err := s.view(func(tx *bolt.Tx) error {
+		indexes := tx.Bucket(indexesBucket)
+		if indexes == nil {
+			return nil
+		}
+		bucket := indexes.Bucket([]byte(index))
+		if bucket == nil {
+			return nil
+		}
+
+		c := bucket.Cursor()
+		var k []byte
+		switch {
+		case ascending && cursor == "":
+			k, _ = c.First()
+		case ascending:
+			// Seek встаёт на первый ключ >= курсора, сам курсор пропускаем
+			if k, _ = c.Seek([]byte(cursor)); k != nil && string(k) == cursor {
+				k, _ = c.Next()
+			}
+		case cursor == "":
+			k, _ = c.Last()
+		default:
+			if k, _ = c.Seek([]byte(cursor)); k == nil {
+				k, _ = c.Last()
+			} else {
+				k, _ = c.Prev()
+			}
+		}
+
+		for k != nil && len(page) < limit {
+			page = append(page, string(k))
+			if ascending {
+				k, _ = c.Next()
+			} else {
+				k, _ = c.Prev()
+			}
+		}
+		return nil
+	})
+	return page, err
+}
+
+// Unindex удаляет элементы индекса; ссылки с истёкшим сроком хранения удаляются вместе с ними
+func (s *BoltStore) Unindex(ctx context.Context, index string, members ...string) error {
+	if len(members) == 0 {
+		return nil
+	}
+	return s.update(func(tx *bolt.Tx) error {
+		now := time.Now()
+		for _, member := range members {
+			// Нечитаемая запись не удаляется: её нельзя отличить от живой ссылки
+			id := MemberID(member)
+			if record, err := readRecord(tx, id, now); record == nil && err == nil {
+				if err := removeRecord(tx, id); err != nil {
+					return err
+				}
+			}
+			if err := deleteMember(tx, index, member); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Scan читает ссылки пачками по scanBatch и вызывает fn вне транзакции: медленный fn (например,
+// экспорт в HTTP-ответ) не должен держать транзакцию чтения, а в режиме Shared - блокировку файла.
+// Ссылки, созданные или удалённые во время обхода, могут попасть или не попасть в него.
+func (s *BoltStore) Scan(ctx context.Context, fn func(id string, record *Record) error) error {
+	var after []byte
+	for {
+		var ids []string
+		var records []*Record
+		err := s.view(func(tx *bolt.Tx) error {
+			links := tx.Bucket(linksBucket)
+			if links == nil {
+				return nil
+			}
+			c := links.Cursor()
+			k, _ := c.First()
+			if after != nil {
+				// Seek встаёт на первый ключ >= последнего обработанного, сам ключ пропускаем
+				if k, _ = c.Seek(after); k != nil && string(k) == string(after) {
+					k, _ = c.Next()
+				}
+			}
+			now := time.Now()
+			for ; k != nil && len(ids) < scanBatch; k, _ = c.Next() {
+				// Ключи из bbolt действительны только внутри транзакции, string копирует их
+				id := string(k)
+				after = []byte(id)
+				if record := getRecord(tx, id, now); record != nil {
+					ids = append(ids, id)
+					records = append(records, record)
+				}
+			}
+			if k == nil {
+				after = nil
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for i, id := range ids {
+			if err := fn(id, records[i]); err != nil {
+				return err
+			}
+		}
+		if after == nil {
+			return nil
+		}
+	}
+}
+
+func (s *BoltStore) GetRef(ctx context.Context, key string) (string, error) {
+	var value string
+	err := s.view(func(tx *bolt.Tx) error {
+		refs := tx.Bucket(refsBucket)
+		if refs == nil {
+			return ErrNotFound
+		}
+		// Значение из bbolt действительно только внутри транзакции, копируем его
+		raw := refs.Get([]byte(key))
+		if raw == nil {
+			return ErrNotFound
+		}
+		value = string(raw)
+		return nil
+	})
+	return value, err
+}
+
+func (s *BoltStore) SetRef(ctx context.Context, key, value string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(refsBucket).Put([]byte(key), []byte(value))
+	})
+}
+
+func (s *BoltStore) DeleteRef(ctx context.Context, key, value string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		refs := tx.Bucket(refsBucket)
+		if string(refs.Get([]byte(key))) != value {
+			return nil
+		}
+		return refs.Delete([]byte(key))
+	})
+}
+
+func (s *BoltStore) SwapRef(ctx context.Context, key, old, value string) (bool, error) {
+	swapped := false
+	err := s.update(func(tx *bolt.Tx) error {
+		refs := tx.Bucket(refsBucket)
+		if string(refs.Get([]byte(key))) != old {
+			return nil
+		}
+		swapped = true
+		return refs.Put([]byte(key), []byte(value))
+	})
+	return swapped && err == nil, err
+}
+
+func (s *BoltStore) Incr(ctx context.Context, key string) (int64, error) {
+	var value int64
+	err := s.update(func(tx *bolt.Tx) error {
+		var err error
+		value, err = incrCounter(tx, key)
+		return err
+	})
+	return value, err
+}
+
+func incrCounter(tx *bolt.Tx, key string) (int64, error) {
+	value := readCounter(tx, key) + 1
+	return value, tx.Bucket(countersBucket).Put([]byte(key), binary.BigEndian.AppendUint64(nil, uint64(value)))
+}
+
+func readCounter(tx *bolt.Tx, key string) int64 {
+	counters := tx.Bucket(countersBucket)
+	if counters == nil {
+		return 0
+	}
+	if raw := counters.Get([]byte(key)); len(raw) == 8 {
+		return int64(binary.BigEndian.Uint64(raw))
+	}
+	return 0
+}
+
+func (s *BoltStore) Redeem(ctx context.Context, id string) (int64, int64, error) {
+	var used, limit int64
+	err := s.update(func(tx *bolt.Tx) error {
+		record := getRecord(tx, id, time.Now())
+		if record == nil {
+			return ErrNotFound
+		}
+		if record.MaxClicks <= 0 {
+			return nil
+		}
+		limit = record.MaxClicks
+		var err error
+		used, err = incrCounter(tx, clicksKey(id))
+		return err
+	})
+	return used, limit, err
+}
+
+func (s *BoltStore) Redemptions(ctx context.Context, id string) (int64, error) {
+	var used int64
+	err := s.view(func(tx *bolt.Tx) error {
+		used = readCounter(tx, clicksKey(id))
+		return nil
+	})
+	return used, err
+}
+
+func (s *BoltStore) Ping(ctx context.Context) error {
+	return s.view(func(tx *bolt.Tx) error {
+		return nil
+	})
+}
+
+func (s *BoltStore) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
@@ -0,0 +1,18 @@
+module github.com/itcaat/url-shortener-demo/pkg/linkstore
+
+go 1.21
+
+require (
+	github.com/alicebob/miniredis/v2 v2.31.0
+	github.com/go-redis/redis/v8 v8.11.5
+	go.etcd.io/bbolt v1.3.8
+	golang.org/x/crypto v0.14.0
+)
+
+require (
+	github.com/alicebob/gopher-json v0.0.0-20200520072559-a9ecdc9d1d3a // indirect
+	github.com/cespare/xxhash/v2 v2.1.2 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/yuin/gopher-lua v1.1.0 // indirect
+	golang.org/x/sys v0.13.0 // indirect
+)
@@ -0,0 +1,49 @@
+package linkstore
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Вторичные индексы ссылок упорядочены лексикографически по элементу
+// "<время создания в мс>|<id>". Это даёт точную курсорную пагинацию
+// даже для ссылок, созданных в одну и ту же миллисекунду.
+const CreatedIndex = "links:created"
+
+func TagIndex(tag string) string {
+	return "links:tag:" + tag
+}
+
+func OwnerIndex(owner string) string {
+	return "links:owner:" + owner
+}
+
+// IndexMember возвращает элемент индекса для ссылки
+func IndexMember(id string, createdAt time.Time) string {
+	var ms int64
+	if !createdAt.IsZero() {
+		ms = createdAt.UnixMilli()
+	}
+	return fmt.Sprintf("%013d|%s", ms, id)
+}
+
+// MemberID извлекает id ссылки из элемента индекса
+func MemberID(member string) string {
+	if i := strings.IndexByte(member, '|'); i >= 0 {
+		return member[i+1:]
+	}
+	return member
+}
+
+// indexesFor возвращает индексы, в которые входит ссылка, и её элемент в них
+func indexesFor(id string, record *Record) ([]string, string) {
+	indexes := []string{CreatedIndex}
+	if record.Owner != "" {
+		indexes = append(indexes, OwnerIndex(record.Owner))
+	}
+	for _, tag := range record.Tags {
+		indexes = append(indexes, TagIndex(tag))
+	}
+	return indexes, IndexMember(id, record.CreatedAt)
+}
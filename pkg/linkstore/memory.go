@@ -0,0 +1,351 @@
+package linkstore
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore хранит ссылки в памяти процесса. Подходит для тестов и запуска одного
+// процесса без Redis; данные теряются при перезапуске и не видны другим сервисам.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	links    map[string]memoryEntry
+	indexes  map[string][]string // отсортированные элементы индексов
+	refs     map[string]string
+	counters map[string]int64
+}
+
+// memoryEntry хранит закодированную запись, чтобы вызывающий код не мог изменить её в обход хранилища
+type memoryEntry struct {
+	value    string
+	deleteAt time.Time
+}
+
+func NewMemory() *MemoryStore {
+	return &MemoryStore{
+		links:    map[string]memoryEntry{},
+		indexes:  map[string][]string{},
+		refs:     map[string]string{},
+		counters: map[string]int64{},
+	}
+}
+
+func (e memoryEntry) expired(now time.Time) bool {
+	return !e.deleteAt.IsZero() && !now.Before(e.deleteAt)
+}
+
+// lookup возвращает живую запись; вызывается под блокировкой
+func (s *MemoryStore) lookup(id string, now time.Time) *Record {
+	record, _ := s.read(id, now)
+	return record
+}
+
+// read возвращает живую запись или ошибку разбора; nil без ошибки - записи нет
+func (s *MemoryStore) read(id string, now time.Time) (*Record, error) {
+	entry, ok := s.links[id]
+	if !ok || entry.expired(now) {
+		return nil, nil
+	}
+	return decodeRecord(entry.value, nil, nil)
+}
+
+// purge удаляет запись с истёкшим сроком хранения вместе с её индексами; вызывается под блокировкой на запись
+func (s *MemoryStore) purge(id string, now time.Time) {
+	entry, ok := s.links[id]
+	if !ok || !entry.expired(now) {
+		return
+	}
+	if record, err := decodeRecord(entry.value, nil, nil); err == nil {
+		s.unindexRecord(id, record)
+	}
+	delete(s.links, id)
+	delete(s.counters, clicksKey(id))
+}
+
+func (s *MemoryStore) Get(ctx context.Context, id string) (*Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, err := s.read(id, time.Now())
+	if err != nil {
+		return nil, &UnreadableError{IDs: []string{id}, Err: err}
+	}
+	if record == nil {
+		return nil, ErrNotFound
+	}
+	return record, nil
+}
+
+func (s *MemoryStore) GetMany(ctx context.Context, ids []string) ([]*Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	records := make([]*Record, len(ids))
+	var unreadable *UnreadableError
+	for i, id := range ids {
+		record, err := s.read(id, now)
+		if err != nil {
+			addUnreadable(&unreadable, id, err)
+			continue
+		}
+		records[i] = record
+	}
+	return records, unreadableErr(unreadable)
+}
+
+func (s *MemoryStore) Create(ctx context.Context, id string, record *Record, ttl time.Duration) (bool, error) {
+	created, err := s.CreateMany(ctx, []Entry{{ID: id, Record: record, TTL: ttl}})
+	if err != nil {
+		return false, err
+	}
+	return created[0], nil
+}
+
+func (s *MemoryStore) CreateMany(ctx context.Context, entries []Entry) ([]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	created := make([]bool, len(entries))
+	for i, entry := range entries {
+		s.purge(entry.ID, now)
+		if _, exists := s.links[entry.ID]; exists {
+			continue
+		}
+		if err := s.store(entry.ID, entry.Record, entry.TTL); err != nil {
+			return nil, err
+		}
+		delete(s.counters, clicksKey(entry.ID))
+		created[i] = true
+	}
+	return created, nil
+}
+
+func (s *MemoryStore) Update(ctx context.Context, id string, record *Record, ttl time.Duration) error {
+	return s.write(id, record, ttl, true)
+}
+
+func (s *MemoryStore) Put(ctx context.Context, id string, record *Record, ttl time.Duration) error {
+	return s.write(id, record, ttl, false)
+}
+
+func (s *MemoryStore) write(id string, record *Record, ttl time.Duration, mustExist bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.purge(id, now)
+	previous := s.lookup(id, now)
+	if previous == nil && mustExist {
+		return ErrNotFound
+	}
+	if previous != nil {
+		s.unindexRecord(id, previous)
+	}
+	if !mustExist {
+		delete(s.counters, clicksKey(id))
+	}
+	return s.store(id, record, ttl)
+}
+
+// store записывает ссылку и добавляет её в индексы; вызывается под блокировкой на запись
+func (s *MemoryStore) store(id string, record *Record, ttl time.Duration) error {
+	value, err := encodeRecord(record)
+	if err != nil {
+		return err
+	}
+	s.links[id] = memoryEntry{value: value, deleteAt: deadline(ttl)}
+
+	indexes, member := indexesFor(id, record)
+	for _, index := range indexes {
+		members := s.indexes[index]
+		i := sort.SearchStrings(members, member)
+		if i < len(members) && members[i] == member {
+			continue
+		}
+		members = append(members, "")
+		copy(members[i+1:], members[i:])
+		members[i] = member
+		s.indexes[index] = members
+	}
+	return nil
+}
+
+func (s *MemoryStore) unindexRecord(id string, record *Record) {
+	indexes, member := indexesFor(id, record)
+	for _, index := range indexes {
+		s.unindex(index, member)
+	}
+}
+
+func (s *MemoryStore) unindex(index, member string) {
+	members := s.indexes[index]
+	i := sort.SearchStrings(members, member)
+	if i == len(members) || members[i] != member {
+		return
+	}
+	members = append(members[:i], members[i+1:]...)
+	if len(members) == 0 {
+		delete(s.indexes, index)
+		return
+	}
+	s.indexes[index] = members
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := s.lookup(id, time.Now())
+	if record == nil {
+		return ErrNotFound
+	}
+	s.unindexRecord(id, record)
+	delete(s.links, id)
+	delete(s.counters, clicksKey(id))
+	return nil
+}
+
+func (s *MemoryStore) Range(ctx context.Context, index, cursor string, ascending bool, limit int) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	members := s.indexes[index]
+	var page []string
+	if ascending {
+		i := sort.SearchStrings(members, cursor)
+		if cursor != "" && i < len(members) && members[i] == cursor {
+			i++
+		}
+		for ; i < len(members) && len(page) < limit; i++ {
+			page = append(page, members[i])
+		}
+		return page, nil
+	}
+
+	i := len(members) - 1
+	if cursor != "" {
+		i = sort.SearchStrings(members, cursor) - 1
+	}
+	for ; i >= 0 && len(page) < limit; i-- {
+		page = append(page, members[i])
+	}
+	return page, nil
+}
+
+// Unindex удаляет элементы индекса; ссылки с истёкшим сроком хранения удаляются вместе с ними
+func (s *MemoryStore) Unindex(ctx context.Context, index string, members ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, member := range members {
+		s.purge(MemberID(member), now)
+		s.unindex(index, member)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Scan(ctx context.Context, fn func(id string, record *Record) error) error {
+	s.mu.RLock()
+	ids := make([]string, 0, len(s.links))
+	for id := range s.links {
+		ids = append(ids, id)
+	}
+	s.mu.RUnlock()
+
+	// Колбэк вызывается без блокировки, чтобы он мог обращаться к хранилищу
+	for _, id := range ids {
+		// Удалённые за время обхода и нечитаемые записи пропускаются
+		record, err := s.Get(ctx, id)
+		if err != nil {
+			continue
+		}
+		if err := fn(id, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetRef(ctx context.Context, key string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	value, ok := s.refs[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (s *MemoryStore) SetRef(ctx context.Context, key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.refs[key] = value
+	return nil
+}
+
+func (s *MemoryStore) DeleteRef(ctx context.Context, key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.refs[key] == value {
+		delete(s.refs, key)
+	}
+	return nil
+}
+
+func (s *MemoryStore) SwapRef(ctx context.Context, key, old, value string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.refs[key] != old {
+		return false, nil
+	}
+	s.refs[key] = value
+	return true, nil
+}
+
+func (s *MemoryStore) Incr(ctx context.Context, key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.counters[key]++
+	return s.counters[key], nil
+}
+
+func (s *MemoryStore) Redeem(ctx context.Context, id string) (int64, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := s.lookup(id, time.Now())
+	if record == nil {
+		return 0, 0, ErrNotFound
+	}
+	if record.MaxClicks <= 0 {
+		return 0, 0, nil
+	}
+	key := clicksKey(id)
+	s.counters[key]++
+	return s.counters[key], record.MaxClicks, nil
+}
+
+func (s *MemoryStore) Redemptions(ctx context.Context, id string) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.counters[clicksKey(id)], nil
+}
+
+func (s *MemoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,72 @@
+package linkstore
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Пароль ссылки хранится в записи как "pbkdf2-sha256$<итерации>$<соль>$<хеш>"
+// (соль и хеш - base64 без дополнения). Число итераций хранится вместе с хешем,
+// поэтому его можно увеличить, не ломая уже защищённые ссылки.
+const (
+	passwordScheme     = "pbkdf2-sha256"
+	passwordIterations = 100_000
+	passwordSaltBytes  = 16
+	passwordKeyBytes   = 32
+)
+
+var passwordEncoding = base64.RawStdEncoding
+
+// HashPassword возвращает хеш пароля со случайной солью
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, passwordSaltBytes)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := pbkdf2.Key([]byte(password), salt, passwordIterations, passwordKeyBytes, sha256.New)
+	return fmt.Sprintf("%s$%d$%s$%s", passwordScheme, passwordIterations,
+		passwordEncoding.EncodeToString(salt), passwordEncoding.EncodeToString(key)), nil
+}
+
+// VerifyPassword сравнивает пароль с хешем за постоянное время; повреждённый хеш не совпадает ни с чем
+func VerifyPassword(encoded, password string) bool {
+	iterations, salt, key, ok := parsePasswordHash(encoded)
+	if !ok {
+		return false
+	}
+	actual := pbkdf2.Key([]byte(password), salt, iterations, len(key), sha256.New)
+	return subtle.ConstantTimeCompare(actual, key) == 1
+}
+
+// ValidPasswordHash проверяет формат хеша, например при импорте
+func ValidPasswordHash(encoded string) bool {
+	_, _, _, ok := parsePasswordHash(encoded)
+	return ok
+}
+
+func parsePasswordHash(encoded string) (iterations int, salt, key []byte, ok bool) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != passwordScheme {
+		return 0, nil, nil, false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	// Больше итераций и длиннее ключ, чем даёт HashPassword, не принимаем: импортированный хеш
+	// не должен делать каждую проверку пароля дороже обычной
+	if err != nil || iterations < 1 || iterations > passwordIterations {
+		return 0, nil, nil, false
+	}
+	if salt, err = passwordEncoding.DecodeString(parts[2]); err != nil || len(salt) == 0 {
+		return 0, nil, nil, false
+	}
+	if key, err = passwordEncoding.DecodeString(parts[3]); err != nil || len(key) != passwordKeyBytes {
+		return 0, nil, nil, false
+	}
+	return iterations, salt, key, true
+}
@@ -0,0 +1,66 @@
+package linkstore
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPasswordRoundTrip(t *testing.T) {
+	encoded, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	if !strings.HasPrefix(encoded, "pbkdf2-sha256$100000$") {
+		t.Errorf("HashPassword = %q, want the pbkdf2-sha256 format", encoded)
+	}
+	if !ValidPasswordHash(encoded) {
+		t.Errorf("ValidPasswordHash(%q) = false", encoded)
+	}
+	if !VerifyPassword(encoded, "correct horse") {
+		t.Error("VerifyPassword rejected the right password")
+	}
+	if VerifyPassword(encoded, "battery staple") {
+		t.Error("VerifyPassword accepted a wrong password")
+	}
+
+	again, _ := HashPassword("correct horse")
+	if again == encoded {
+		t.Error("two hashes of the same password share a salt")
+	}
+}
+
+// Хеши, сохранённые раньше, должны проверяться и после смены реализации PBKDF2
+func TestVerifyPasswordKnownHash(t *testing.T) {
+	const encoded = "pbkdf2-sha256$1000$MDEyMzQ1Njc4OWFiY2RlZg$cBg8D2DungRB9k76szThf5ehfyBz991ay6PT8Srwk4M"
+	if !VerifyPassword(encoded, "correct horse") {
+		t.Error("VerifyPassword rejected a stored hash")
+	}
+	if VerifyPassword(encoded, "Correct horse") {
+		t.Error("VerifyPassword accepted a wrong password")
+	}
+}
+
+func TestInvalidPasswordHash(t *testing.T) {
+	tests := []string{
+		"",
+		"correct horse",
+		"bcrypt$1000$MDEyMzQ1Njc4OWFiY2RlZg$cBg8D2DungRB9k76szThf5ehfyBz991ay6PT8Srwk4M",
+		"pbkdf2-sha256$0$MDEyMzQ1Njc4OWFiY2RlZg$cBg8D2DungRB9k76szThf5ehfyBz991ay6PT8Srwk4M",
+		"pbkdf2-sha256$many$MDEyMzQ1Njc4OWFiY2RlZg$cBg8D2DungRB9k76szThf5ehfyBz991ay6PT8Srwk4M",
+		"pbkdf2-sha256$100001$MDEyMzQ1Njc4OWFiY2RlZg$cBg8D2DungRB9k76szThf5ehfyBz991ay6PT8Srwk4M",
+		"pbkdf2-sha256$99999999$MDEyMzQ1Njc4OWFiY2RlZg$cBg8D2DungRB9k76szThf5ehfyBz991ay6PT8Srwk4M",
+		"pbkdf2-sha256$1000$$cBg8D2DungRB9k76szThf5ehfyBz991ay6PT8Srwk4M",
+		"pbkdf2-sha256$1000$MDEyMzQ1Njc4OWFiY2RlZg$",
+		"pbkdf2-sha256$1000$not base64!$cBg8D2DungRB9k76szThf5ehfyBz991ay6PT8Srwk4M",
+		"pbkdf2-sha256$1000$MDEyMzQ1Njc4OWFiY2RlZg",
+		"pbkdf2-sha256$1000$MDEyMzQ1Njc4OWFiY2RlZg$" + strings.Repeat("A", 4000),
+	}
+	for _, encoded := range tests {
+		if ValidPasswordHash(encoded) {
+			t.Errorf("ValidPasswordHash(%q) = true", encoded)
+		}
+		if VerifyPassword(encoded, "correct horse") {
+			t.Errorf("VerifyPassword(%q) = true", encoded)
+		}
+	}
+}
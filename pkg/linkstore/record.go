@@ -0,0 +1,113 @@
+package linkstore
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Текущая версия формата записи ссылки
+const RecordVersion = 1
+
+const StateDisabled = "disabled"
+
+// Политики передачи параметров запроса короткой ссылки в адрес перехода (Record.QueryPassthrough)
+const (
+	// Параметры адреса важнее: добавляются только отсутствующие в нём
+	QueryMerge = "merge"
+	// Параметры запроса заменяют одноимённые параметры адреса
+	QueryOverride = "override"
+)
+
+// Коды перенаправления, которые можно задать ссылке (Record.RedirectStatus)
+var RedirectStatuses = []int{301, 302, 307, 308}
+
+// Record - версионированная запись ссылки, хранится как JSON.
+// Ранние версии сервиса хранили в url:<id> только строку с адресом,
+// а срок действия и метаданные - в отдельных ключах expires:<id> и meta:<id>.
+type Record struct {
+	Version   int        `json:"v"`
+	URL       string     `json:"url"`
+	Title     string     `json:"title,omitempty"`
+	Owner     string     `json:"owner,omitempty"`
+	Tags      []string   `json:"tags,omitempty"`
+	State     string     `json:"state,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// Хеш пароля (см. HashPassword); пустой - ссылка открыта
+	PasswordHash string `json:"passwordHash,omitempty"`
+	// Сколько переходов разрешено (см. Store.Redeem); 0 - без ограничения
+	MaxClicks int64 `json:"maxClicks,omitempty"`
+	// Окно активности: до ActiveFrom ссылка ведёт на FallbackURL (или не открывается),
+	// после ActiveUntil перестаёт открываться, но запись остаётся
+	ActiveFrom  *time.Time `json:"activeFrom,omitempty"`
+	ActiveUntil *time.Time `json:"activeUntil,omitempty"`
+	FallbackURL string     `json:"fallbackUrl,omitempty"`
+	// Правила выбора адреса по устройству и стране посетителя и по расписанию (см. Rule)
+	Rules []Rule `json:"rules,omitempty"`
+	// Часовой пояс IANA для расписаний правил; пустой - UTC
+	TimeZone string `json:"timeZone,omitempty"`
+	// Взвешенные адреса A/B-теста (см. Variant)
+	Variants []Variant `json:"variants,omitempty"`
+	// Передавать ли в адрес перехода параметры запроса (QueryMerge, QueryOverride; пусто - нет)
+	// и путь после кода: /abc/docs/intro ведёт на <адрес>/docs/intro
+	QueryPassthrough string `json:"queryPassthrough,omitempty"`
+	PathPassthrough  bool   `json:"pathPassthrough,omitempty"`
+	// Код перенаправления (см. RedirectStatuses); 0 - код по умолчанию redirect-service
+	RedirectStatus int `json:"redirectStatus,omitempty"`
+}
+
+// legacyMeta - формат устаревшего ключа meta:<id>
+type legacyMeta struct {
+	Owner     string    `json:"owner,omitempty"`
+	Tags      []string  `json:"tags,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	Disabled  bool      `json:"disabled,omitempty"`
+}
+
+// isLegacyValue сообщает, что значение хранится в старом строковом формате
+func isLegacyValue(value string) bool {
+	return !strings.HasPrefix(value, "{")
+}
+
+// decodeRecord разбирает значение записи. Для строкового формата
+// запись собирается из адреса и устаревших ключей expires:<id> и meta:<id>.
+func decodeRecord(value string, expires, meta interface{}) (*Record, error) {
+	record := &Record{}
+	if !isLegacyValue(value) {
+		if err := json.Unmarshal([]byte(value), record); err != nil {
+			return nil, err
+		}
+		return record, nil
+	}
+
+	record.URL = value
+	if raw, ok := expires.(string); ok {
+		if unix, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			expiresAt := time.Unix(unix, 0).UTC()
+			record.ExpiresAt = &expiresAt
+		}
+	}
+	if raw, ok := meta.(string); ok {
+		var m legacyMeta
+		if err := json.Unmarshal([]byte(raw), &m); err != nil {
+			return nil, err
+		}
+		record.Owner = m.Owner
+		record.Tags = m.Tags
+		record.CreatedAt = m.CreatedAt
+		if m.Disabled {
+			record.State = StateDisabled
+		}
+	}
+	return record, nil
+}
+
+func encodeRecord(record *Record) (string, error) {
+	stored := *record
+	stored.Version = RecordVersion
+	data, err := json.Marshal(stored)
+	return string(data), err
+}
@@ -0,0 +1,465 @@
+package linkstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisStore хранит ссылку в url:<id> (JSON-запись), индексы - в sorted set'ах
+// с одинаковым score (выборка через ZRANGEBYLEX), указатели - в обычных ключах.
+type RedisStore struct {
+	client *redis.Client
+}
+
+// Сколько раз повторять транзакцию WATCH, если её ключи изменились параллельно
+const watchRetries = 10
+
+// redeemScript читает лимит из записи url:<id> и увеличивает счётчик clicks:<id> в одной операции,
+// поэтому параллельные переходы не превысят лимит. Счётчик живёт столько же, сколько ссылка.
+var redeemScript = redis.NewScript(`
+local value = redis.call('GET', KEYS[1])
+if not value then
+	return {-1, 0}
+end
+if string.sub(value, 1, 1) ~= '{' then
+	return {0, 0}
+end
+local ok, record = pcall(cjson.decode, value)
+if not ok or type(record) ~= 'table' or type(record.maxClicks) ~= 'number' or record.maxClicks <= 0 then
+	return {0, 0}
+end
+local used = redis.call('INCR', KEYS[2])
+if used == 1 then
+	local ttl = redis.call('PTTL', KEYS[1])
+	if ttl > 0 then
+		redis.call('PEXPIRE', KEYS[2], ttl)
+	end
+end
+return {used, record.maxClicks}
+`)
+
+func NewRedis(addr string) *RedisStore {
+	return &RedisStore{client: redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: "",
+		DB:       0,
+	})}
+}
+
+// watch выполняет fn в транзакции WATCH по keys и повторяет её, пока ключи меняются параллельно
+func (s *RedisStore) watch(ctx context.Context, fn func(tx *redis.Tx) error, keys ...string) error {
+	for attempt := 1; ; attempt++ {
+		err := s.client.Watch(ctx, fn, keys...)
+		if err != redis.TxFailedErr || attempt == watchRetries {
+			return err
+		}
+	}
+}
+
+// linkKeys возвращает ключи, из которых собирается ссылка, включая устаревшие ключи строкового формата
+func linkKeys(id string) []string {
+	return []string{"url:" + id, "expires:" + id, "meta:" + id}
+}
+
+// parseValues собирает запись из значений linkKeys; nil - ссылки нет
+func parseValues(values []interface{}) (*Record, error) {
+	value, ok := values[0].(string)
+	if !ok {
+		return nil, nil
+	}
+	return decodeRecord(value, values[1], values[2])
+}
+
+func addToIndexes(ctx context.Context, pipe redis.Pipeliner, id string, record *Record) {
+	indexes, member := indexesFor(id, record)
+	for _, index := range indexes {
+		pipe.ZAdd(ctx, index, &redis.Z{Member: member})
+	}
+}
+
+func removeFromIndexes(ctx context.Context, pipe redis.Pipeliner, id string, record *Record) {
+	indexes, member := indexesFor(id, record)
+	for _, index := range indexes {
+		pipe.ZRem(ctx, index, member)
+	}
+}
+
+func (s *RedisStore) Get(ctx context.Context, id string) (*Record, error) {
+	values, err := s.client.MGet(ctx, linkKeys(id)...).Result()
+	if err != nil {
+		return nil, err
+	}
+	record, err := parseValues(values)
+	if err != nil {
+		return nil, &UnreadableError{IDs: []string{id}, Err: err}
+	}
+	if record == nil {
+		return nil, ErrNotFound
+	}
+	return record, nil
+}
+
+func (s *RedisStore) GetMany(ctx context.Context, ids []string) ([]*Record, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	pipe := s.client.Pipeline()
+	cmds := make([]*redis.SliceCmd, len(ids))
+	for i, id := range ids {
+		cmds[i] = pipe.MGet(ctx, linkKeys(id)...)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	records := make([]*Record, len(ids))
+	var unreadable *UnreadableError
+	for i, id := range ids {
+		record, err := parseValues(cmds[i].Val())
+		if err != nil {
+			addUnreadable(&unreadable, id, err)
+			continue
+		}
+		records[i] = record
+	}
+	return records, unreadableErr(unreadable)
+}
+
+// Create занимает id через SETNX и индексирует ссылку; если индексы не записались, id освобождается
+func (s *RedisStore) Create(ctx context.Context, id string, record *Record, ttl time.Duration) (bool, error) {
+	created, err := s.CreateMany(ctx, []Entry{{ID: id, Record: record, TTL: ttl}})
+	if err != nil {
+		return false, err
+	}
+	return created[0], nil
+}
+
+func (s *RedisStore) CreateMany(ctx context.Context, entries []Entry) ([]bool, error) {
+	pipe := s.client.Pipeline()
+	cmds := make([]*redis.BoolCmd, len(entries))
+	for i, entry := range entries {
+		value, err := encodeRecord(entry.Record)
+		if err != nil {
+			return nil, err
+		}
+		cmds[i] = pipe.SetNX(ctx, "url:"+entry.ID, value, entry.TTL)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	created := make([]bool, len(entries))
+	index := s.client.TxPipeline()
+	for i, entry := range entries {
+		if created[i] = cmds[i].Val(); created[i] {
+			addToIndexes(ctx, index, entry.ID, entry.Record)
+			// Счётчик переходов мог остаться от удалённой ссылки с тем же id
+			index.Del(ctx, clicksKey(entry.ID))
+		}
+	}
+	if _, err := index.Exec(ctx); err != nil && err != redis.Nil {
+		// Индексы не записаны - освобождаем коды, чтобы не оставлять ссылки вне индексов
+		cleanup := s.client.Pipeline()
+		for i, entry := range entries {
+			if created[i] {
+				cleanup.Del(ctx, "url:"+entry.ID)
+			}
+		}
+		cleanup.Exec(ctx)
+		return nil, err
+	}
+	return created, nil
+}
+
+func (s *RedisStore) Update(ctx context.Context, id string, record *Record, ttl time.Duration) error {
+	return s.write(ctx, id, record, ttl, true)
+}
+
+func (s *RedisStore) Put(ctx context.Context, id string, record *Record, ttl time.Duration) error {
+	return s.write(ctx, id, record, ttl, false)
+}
+
+// write перезаписывает ссылку и обновляет индексы относительно прежней записи.
+// WATCH гарантирует, что ссылка не изменилась параллельно между чтением и записью; иначе запись повторяется.
+// Устаревшие ключи expires: и meta: удаляются - после записи ссылка хранится только в новом формате.
+func (s *RedisStore) write(ctx context.Context, id string, record *Record, ttl time.Duration, mustExist bool) error {
+	value, err := encodeRecord(record)
+	if err != nil {
+		return err
+	}
+	keys := linkKeys(id)
+
+	return s.watch(ctx, func(tx *redis.Tx) error {
+		values, err := tx.MGet(ctx, keys...).Result()
+		if err != nil {
+			return err
+		}
+		previous, _ := parseValues(values)
+		if previous == nil && mustExist {
+			return ErrNotFound
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, "url:"+id, value, ttl)
+			pipe.Del(ctx, "expires:"+id, "meta:"+id)
+			if previous != nil {
+				removeFromIndexes(ctx, pipe, id, previous)
+			}
+			addToIndexes(ctx, pipe, id, record)
+			// Счётчик переходов живёт столько же, сколько ссылка; Put заменяет ссылку вместе со счётчиком
+			if !mustExist {
+				pipe.Del(ctx, clicksKey(id))
+			} else if ttl > 0 {
+				pipe.PExpire(ctx, clicksKey(id), ttl)
+			} else {
+				pipe.Persist(ctx, clicksKey(id))
+			}
+			return nil
+		})
+		return err
+	}, keys...)
+}
+
+// Delete удаляет ссылку и элементы индексов той записи, что прочитана под WATCH:
+// параллельное изменение ссылки перезапускает удаление, чтобы не оставить её новые индексы
+func (s *RedisStore) Delete(ctx context.Context, id string) error {
+	keys := linkKeys(id)
+	return s.watch(ctx, func(tx *redis.Tx) error {
+		values, err := tx.MGet(ctx, keys...).Result()
+		if err != nil {
+			return err
+		}
+		record, err := parseValues(values)
+		if err != nil {
+			return &UnreadableError{IDs: []string{id}, Err: err}
+		}
+		if record == nil {
+			return ErrNotFound
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, append(keys, clicksKey(id))...)
+			removeFromIndexes(ctx, pipe, id, record)
+			return nil
+		})
+		return err
+	}, keys...)
+}
+
+func (s *RedisStore) Range(ctx context.Context, index, cursor string, ascending bool, limit int) ([]string, error) {
+	if ascending {
+		min := "-"
+		if cursor != "" {
+			min = "(" + cursor
+		}
+		return s.client.ZRangeByLex(ctx, index, &redis.ZRangeBy{Min: min, Max: "+", Count: int64(limit)}).Result()
+	}
+
+	max := "+"
+	if cursor != "" {
+		max = "(" + cursor
+	}
+	return s.client.ZRevRangeByLex(ctx, index, &redis.ZRangeBy{Min: "-", Max: max, Count: int64(limit)}).Result()
+}
+
+func (s *RedisStore) Unindex(ctx context.Context, index string, members ...string) error {
+	if len(members) == 0 {
+		return nil
+	}
+	values := make([]interface{}, len(members))
+	for i, member := range members {
+		values[i] = member
+	}
+	return s.client.ZRem(ctx, index, values...).Err()
+}
+
+// Scan обходит url:* через SCAN, загружая ссылки пачками; нечитаемые записи пропускаются
+func (s *RedisStore) Scan(ctx context.Context, fn func(id string, record *Record) error) error {
+	var batch []string
+	flush := func() error {
+		records, err := s.GetMany(ctx, batch)
+		var unreadable *UnreadableError
+		if err != nil && !errors.As(err, &unreadable) {
+			return err
+		}
+		for i, record := range records {
+			if record == nil {
+				continue
+			}
+			if err := fn(batch[i], record); err != nil {
+				return err
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	iter := s.client.Scan(ctx, 0, "url:*", 500).Iterator()
+	for iter.Next(ctx) {
+		batch = append(batch, strings.TrimPrefix(iter.Val(), "url:"))
+		if len(batch) >= 500 {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+func (s *RedisStore) GetRef(ctx context.Context, key string) (string, error) {
+	value, err := s.client.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return "", ErrNotFound
+	}
+	return value, err
+}
+
+func (s *RedisStore) SetRef(ctx context.Context, key, value string) error {
+	return s.client.Set(ctx, key, value, 0).Err()
+}
+
+func (s *RedisStore) DeleteRef(ctx context.Context, key, value string) error {
+	return s.watch(ctx, func(tx *redis.Tx) error {
+		current, err := tx.Get(ctx, key).Result()
+		if err == redis.Nil {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if current != value {
+			return nil
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			return nil
+		})
+		return err
+	}, key)
+}
+
+func (s *RedisStore) SwapRef(ctx context.Context, key, old, value string) (bool, error) {
+	err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := tx.Get(ctx, key).Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		if current != old {
+			return redis.TxFailedErr
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, value, 0)
+			return nil
+		})
+		return err
+	}, key)
+	if err == redis.TxFailedErr {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *RedisStore) Incr(ctx context.Context, key string) (int64, error) {
+	return s.client.Incr(ctx, key).Result()
+}
+
+func (s *RedisStore) Redeem(ctx context.Context, id string) (int64, int64, error) {
+	result, err := redeemScript.Run(ctx, s.client, []string{"url:" + id, clicksKey(id)}).Int64Slice()
+	if err != nil {
+		return 0, 0, err
+	}
+	if result[0] < 0 {
+		return 0, 0, ErrNotFound
+	}
+	return result[0], result[1], nil
+}
+
+func (s *RedisStore) Redemptions(ctx context.Context, id string) (int64, error) {
+	used, err := s.client.Get(ctx, clicksKey(id)).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return used, err
+}
+
+func (s *RedisStore) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
+
+// MigrateLegacy переводит ссылки из строкового формата (url:<id> + expires:<id> + meta:<id>)
+// в версионированные записи и добавляет их во вторичные индексы
+func (s *RedisStore) MigrateLegacy(ctx context.Context, dryRun bool, onError func(id string, err error)) (MigrationResult, error) {
+	var result MigrationResult
+	iter := s.client.Scan(ctx, 0, "url:*", 500).Iterator()
+	for iter.Next(ctx) {
+		result.Scanned++
+		id := strings.TrimPrefix(iter.Val(), "url:")
+
+		migrated, err := s.migrateLink(ctx, id, dryRun)
+		if err != nil {
+			result.Failed++
+			if onError != nil {
+				onError(id, err)
+			}
+			continue
+		}
+		if migrated {
+			result.Migrated++
+		}
+	}
+	return result, iter.Err()
+}
+
+// migrateLink конвертирует одну ссылку; false означает, что она уже в новом формате или исчезла
+func (s *RedisStore) migrateLink(ctx context.Context, id string, dryRun bool) (bool, error) {
+	migrated := false
+	keys := linkKeys(id)
+
+	err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+		values, err := tx.MGet(ctx, keys...).Result()
+		if err != nil {
+			return err
+		}
+		value, ok := values[0].(string)
+		if !ok || !isLegacyValue(value) {
+			return nil
+		}
+
+		record, err := decodeRecord(value, values[1], values[2])
+		if err != nil {
+			return fmt.Errorf("decode legacy link: %w", err)
+		}
+		migrated = true
+		if dryRun {
+			return nil
+		}
+
+		encoded, err := encodeRecord(record)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			// KEEPTTL сохраняет срок хранения истекающих ссылок
+			pipe.SetArgs(ctx, "url:"+id, encoded, redis.SetArgs{KeepTTL: true})
+			pipe.Del(ctx, "expires:"+id, "meta:"+id)
+			addToIndexes(ctx, pipe, id, record)
+			return nil
+		})
+		return err
+	}, keys...)
+
+	return migrated, err
+}
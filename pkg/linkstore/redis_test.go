@@ -0,0 +1,33 @@
+package linkstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// Ошибки Redis не должны выдаваться за успешное удаление указателя или ссылки
+func TestRedisErrorsAreReported(t *testing.T) {
+	server := miniredis.RunT(t)
+	store := NewRedis(server.Addr())
+	defer store.Close()
+	ctx := context.Background()
+
+	if err := store.SetRef(ctx, "ref", "abc"); err != nil {
+		t.Fatal(err)
+	}
+	server.SetError("LOADING Redis is loading the dataset in memory")
+
+	if err := store.DeleteRef(ctx, "ref", "abc"); err == nil {
+		t.Error("DeleteRef reported success while Redis was failing")
+	}
+	if err := store.Delete(ctx, "abc"); err == nil || err == ErrNotFound {
+		t.Errorf("Delete error = %v, want the Redis error", err)
+	}
+
+	server.SetError("")
+	if value, err := store.GetRef(ctx, "ref"); err != nil || value != "abc" {
+		t.Errorf("GetRef = %q, %v; the ref must survive the failed DeleteRef", value, err)
+	}
+}
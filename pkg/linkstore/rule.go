@@ -0,0 +1,187 @@
+package linkstore
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	// Встроенная база часовых поясов: в образах alpine нет /usr/share/zoneinfo
+	_ "time/tzdata"
+)
+
+// Платформы и типы устройств, которые redirect-service определяет по User-Agent
+const (
+	PlatformIOS      = "ios"
+	PlatformAndroid  = "android"
+	PlatformWindows  = "windows"
+	PlatformMacOS    = "macos"
+	PlatformLinux    = "linux"
+	PlatformChromeOS = "chromeos"
+
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceDesktop = "desktop"
+	DeviceBot     = "bot"
+)
+
+// Ветка посетителей, которым не подошло ни одно правило ссылки
+const DefaultRoute = "default"
+
+var (
+	Platforms = []string{PlatformIOS, PlatformAndroid, PlatformWindows, PlatformMacOS, PlatformLinux, PlatformChromeOS}
+	Devices   = []string{DeviceMobile, DeviceTablet, DeviceDesktop, DeviceBot}
+
+	// Дни недели в расписании, по индексу time.Weekday
+	Weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// Rule - правило выбора адреса перехода. Правила проверяются по порядку, срабатывает первое,
+// у которого совпали все заданные условия; если не совпало ни одно, используется Record.URL.
+type Rule struct {
+	// Имя ветки, под которым переход попадает в аналитику
+	Name      string   `json:"name"`
+	Platforms []string `json:"platforms,omitempty"`
+	Devices   []string `json:"devices,omitempty"`
+	// Коды стран ISO 3166-1 alpha-2 в верхнем регистре; страну определяет база GeoIP redirect-service
+	Countries []string `json:"countries,omitempty"`
+	// Недельное расписание в часовом поясе ссылки (Record.TimeZone): совпадает любое из окон
+	Schedule []TimeWindow `json:"schedule,omitempty"`
+	URL      string       `json:"url"`
+}
+
+// TimeWindow - интервал времени суток "HH:MM" в указанные дни недели (пусто - каждый день).
+// Until не входит в окно, "24:00" - конец суток. Until раньше From - окно через полночь,
+// день недели в этом случае относится к его началу.
+type TimeWindow struct {
+	Days  []string `json:"days,omitempty"`
+	From  string   `json:"from"`
+	Until string   `json:"until"`
+}
+
+// Variant - один из адресов A/B-теста. Посетители, которых не перенаправило ни одно правило,
+// делятся между вариантами пропорционально весам; Record.URL в этом случае не используется.
+type Variant struct {
+	// Имя варианта, под которым переход попадает в аналитику
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// Visitor - то, что известно о посетителе и моменте перехода для выбора ветки правил
+type Visitor struct {
+	Platform string
+	Device   string
+	// Код страны ISO 3166-1 alpha-2; пустой - страна не определена
+	Country string
+	Time    time.Time
+}
+
+// Route выбирает адрес перехода по правилам ссылки: первое правило, у которого совпали
+// все условия. Возвращает адрес, имя ветки (DefaultRoute, если ни одно правило не подошло;
+// пустое имя - у ссылки нет правил) и признак того, что сработало правило.
+func (r *Record) Route(v Visitor) (destination, route string, matched bool) {
+	if len(r.Rules) == 0 {
+		return r.URL, "", false
+	}
+	local := v.Time.In(r.Location())
+	for _, rule := range r.Rules {
+		if matchCondition(rule.Platforms, v.Platform) && matchCondition(rule.Devices, v.Device) &&
+			matchCondition(rule.Countries, v.Country) && matchSchedule(rule.Schedule, local) {
+			return rule.URL, rule.Name, true
+		}
+	}
+	return r.URL, DefaultRoute, false
+}
+
+// Location возвращает часовой пояс ссылки; пустой или неизвестный пояс - UTC
+func (r *Record) Location() *time.Location {
+	if r.TimeZone == "" {
+		return time.UTC
+	}
+	loc, err := LoadLocation(r.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// Загруженные часовые пояса: time.LoadLocation каждый раз заново разбирает базу
+var locations sync.Map
+
+// LoadLocation загружает часовой пояс IANA (например, Europe/Berlin) и кеширует его
+func LoadLocation(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// ParseClock разбирает время суток "HH:MM" в минуты от полуночи; "24:00" - конец суток
+func ParseClock(value string) (int, error) {
+	if len(value) != 5 || value[2] != ':' || !isDigits(value[:2]) || !isDigits(value[3:]) {
+		return 0, fmt.Errorf("time '%s' must be in HH:MM format", value)
+	}
+	hours := int(value[0]-'0')*10 + int(value[1]-'0')
+	minutes := int(value[3]-'0')*10 + int(value[4]-'0')
+	if hours > 24 || minutes > 59 || hours == 24 && minutes != 0 {
+		return 0, fmt.Errorf("time '%s' is out of range 00:00-24:00", value)
+	}
+	return hours*60 + minutes, nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// matchCondition - пустое условие совпадает с любым значением
+func matchCondition(allowed []string, value string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, a := range allowed {
+		if a == value {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSchedule сообщает, попадает ли местное время в одно из окон; пустое расписание совпадает всегда
+func matchSchedule(schedule []TimeWindow, local time.Time) bool {
+	if len(schedule) == 0 {
+		return true
+	}
+	minute := local.Hour()*60 + local.Minute()
+	today := Weekdays[local.Weekday()]
+	yesterday := Weekdays[(local.Weekday()+6)%7]
+	for _, window := range schedule {
+		from, err := ParseClock(window.From)
+		if err != nil {
+			continue
+		}
+		until, err := ParseClock(window.Until)
+		if err != nil {
+			continue
+		}
+		if from < until {
+			if matchCondition(window.Days, today) && minute >= from && minute < until {
+				return true
+			}
+			continue
+		}
+		// Окно через полночь: вечер дня начала или утро следующего за ним дня
+		if matchCondition(window.Days, today) && minute >= from || matchCondition(window.Days, yesterday) && minute < until {
+			return true
+		}
+	}
+	return false
+}
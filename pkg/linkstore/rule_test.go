@@ -0,0 +1,83 @@
+package linkstore
+
+import (
+	"testing"
+	"time"
+)
+
+// at возвращает местное время на неделе, начинающейся с понедельника 12 октября 2026
+func at(day string, hour, minute int) time.Time {
+	for i, weekday := range []string{"mon", "tue", "wed", "thu", "fri", "sat", "sun"} {
+		if weekday == day {
+			return time.Date(2026, 10, 12+i, hour, minute, 0, 0, time.UTC)
+		}
+	}
+	panic("unknown weekday " + day)
+}
+
+func TestMatchSchedule(t *testing.T) {
+	workHours := []TimeWindow{{Days: []string{"mon", "tue", "wed", "thu", "fri"}, From: "09:00", Until: "18:00"}}
+	friNight := []TimeWindow{{Days: []string{"fri"}, From: "22:00", Until: "06:00"}}
+
+	tests := []struct {
+		name     string
+		schedule []TimeWindow
+		local    time.Time
+		want     bool
+	}{
+		{"empty schedule", nil, at("sun", 3, 0), true},
+		{"inside window", workHours, at("wed", 12, 30), true},
+		{"from is inclusive", workHours, at("mon", 9, 0), true},
+		{"until is exclusive", workHours, at("mon", 18, 0), false},
+		{"before window", workHours, at("mon", 8, 59), false},
+		{"wrong day", workHours, at("sat", 12, 0), false},
+		{"any day", []TimeWindow{{From: "09:00", Until: "18:00"}}, at("sun", 12, 0), true},
+		{"until midnight", []TimeWindow{{From: "20:00", Until: "24:00"}}, at("tue", 23, 59), true},
+		{"overnight evening of start day", friNight, at("fri", 23, 0), true},
+		{"overnight morning of next day", friNight, at("sat", 5, 59), true},
+		{"overnight ends at until", friNight, at("sat", 6, 0), false},
+		{"overnight morning of start day", friNight, at("fri", 3, 0), false},
+		{"overnight evening of next day", friNight, at("sat", 23, 0), false},
+		{"overnight from sunday wraps the week", []TimeWindow{{Days: []string{"sun"}, From: "22:00", Until: "02:00"}}, at("mon", 1, 0), true},
+		{"any window matches", append(append([]TimeWindow{}, workHours...), friNight...), at("sat", 1, 0), true},
+		{"malformed window is skipped", []TimeWindow{{From: "9am", Until: "18:00"}}, at("mon", 12, 0), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchSchedule(tt.schedule, tt.local); got != tt.want {
+				t.Errorf("matchSchedule(%s %s) = %v, want %v", tt.local.Weekday(), tt.local.Format("15:04"), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoute(t *testing.T) {
+	record := &Record{
+		URL: "https://example.com",
+		Rules: []Rule{
+			{Name: "ios", Platforms: []string{PlatformIOS}, URL: "https://apps.apple.com/app"},
+			{Name: "de", Countries: []string{"DE"}, URL: "https://example.de"},
+		},
+	}
+	tests := []struct {
+		name        string
+		record      *Record
+		visitor     Visitor
+		destination string
+		route       string
+		matched     bool
+	}{
+		{"no rules", &Record{URL: "https://example.com"}, Visitor{Platform: PlatformIOS}, "https://example.com", "", false},
+		{"first matching rule wins", record, Visitor{Platform: PlatformIOS, Country: "DE"}, "https://apps.apple.com/app", "ios", true},
+		{"second rule", record, Visitor{Platform: PlatformAndroid, Country: "DE"}, "https://example.de", "de", true},
+		{"no rule matched", record, Visitor{Platform: PlatformAndroid, Country: "FR"}, "https://example.com", DefaultRoute, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			destination, route, matched := tt.record.Route(tt.visitor)
+			if destination != tt.destination || route != tt.route || matched != tt.matched {
+				t.Errorf("Route = %q, %q, %v; want %q, %q, %v", destination, route, matched, tt.destination, tt.route, tt.matched)
+			}
+		})
+	}
+}
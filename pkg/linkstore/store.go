@@ -0,0 +1,159 @@
+package linkstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotFound возвращается, если ссылки (или ссылки-указателя) нет в хранилище
+var ErrNotFound = errors.New("link not found")
+
+// UnreadableError - записи есть в хранилище, но их не удалось разобрать (повреждены или записаны
+// в неизвестном формате). Такие ссылки нельзя считать удалёнными: их нельзя убирать из индексов.
+type UnreadableError struct {
+	IDs []string
+	Err error // ошибка разбора первой из записей
+}
+
+func (e *UnreadableError) Error() string {
+	if len(e.IDs) == 1 {
+		return fmt.Sprintf("decode link %s: %v", e.IDs[0], e.Err)
+	}
+	return fmt.Sprintf("decode %d links (first %s): %v", len(e.IDs), e.IDs[0], e.Err)
+}
+
+func (e *UnreadableError) Unwrap() error {
+	return e.Err
+}
+
+// addUnreadable добавляет нечитаемую запись к ошибке GetMany
+func addUnreadable(unreadable **UnreadableError, id string, err error) {
+	if *unreadable == nil {
+		*unreadable = &UnreadableError{Err: err}
+	}
+	(*unreadable).IDs = append((*unreadable).IDs, id)
+}
+
+// unreadableErr возвращает ошибку GetMany без типизированного nil
+func unreadableErr(unreadable *UnreadableError) error {
+	if unreadable == nil {
+		return nil
+	}
+	return unreadable
+}
+
+// Entry - ссылка для пакетной записи
+type Entry struct {
+	ID     string
+	Record *Record
+	TTL    time.Duration
+}
+
+// Store - хранилище ссылок, общее для shortener-service и redirect-service.
+//
+// Ссылка адресуется идентификатором ("code" или "domain/code") и хранится вместе с
+// вторичными индексами (см. CreatedIndex, TagIndex, OwnerIndex), которые хранилище
+// поддерживает само при записи и удалении. TTL - срок хранения записи, 0 - бессрочно.
+type Store interface {
+	// Get возвращает ссылку, ErrNotFound или *UnreadableError
+	Get(ctx context.Context, id string) (*Record, error)
+	// GetMany возвращает ссылки в порядке ids; отсутствующие - nil. Нечитаемые записи тоже nil,
+	// но тогда вместе со списком возвращается *UnreadableError с их id
+	GetMany(ctx context.Context, ids []string) ([]*Record, error)
+	// Create записывает новую ссылку, только если id свободен; false - id уже занят
+	Create(ctx context.Context, id string, record *Record, ttl time.Duration) (bool, error)
+	// CreateMany - пакетный Create; результат по каждой записи в порядке entries
+	CreateMany(ctx context.Context, entries []Entry) ([]bool, error)
+	// Update перезаписывает существующую ссылку или возвращает ErrNotFound
+	Update(ctx context.Context, id string, record *Record, ttl time.Duration) error
+	// Put создаёт или заменяет ссылку; в отличие от Update сбрасывает счётчик переходов (см. Redeem)
+	Put(ctx context.Context, id string, record *Record, ttl time.Duration) error
+	// Delete удаляет ссылку вместе с элементами индексов
+	Delete(ctx context.Context, id string) error
+
+	// Range возвращает элементы индекса строго после cursor (пустой - с начала) в лексикографическом порядке
+	Range(ctx context.Context, index, cursor string, ascending bool, limit int) ([]string, error)
+	// Unindex удаляет устаревшие элементы индекса
+	Unindex(ctx context.Context, index string, members ...string) error
+	// Scan обходит все ссылки без гарантии порядка
+	Scan(ctx context.Context, fn func(id string, record *Record) error) error
+
+	// GetRef, SetRef и DeleteRef работают с короткими строковыми указателями (например, индекс дедупликации)
+	GetRef(ctx context.Context, key string) (string, error)
+	SetRef(ctx context.Context, key, value string) error
+	// DeleteRef удаляет указатель, только если он всё ещё равен value
+	DeleteRef(ctx context.Context, key, value string) error
+	// SwapRef записывает value, только если указатель всё ещё равен old (пустой old - указателя нет);
+	// false - указатель успели изменить, его нужно перечитать и повторить изменение
+	SwapRef(ctx context.Context, key, old, value string) (bool, error)
+
+	// Incr атомарно увеличивает счётчик на 1 и возвращает новое значение (первое - 1)
+	Incr(ctx context.Context, key string) (int64, error)
+
+	// Redeem засчитывает переход по ссылке с ограничением MaxClicks: атомарно с чтением записи
+	// увеличивает счётчик переходов и возвращает его вместе с текущим лимитом. Для ссылок без
+	// ограничения возвращает (0, 0). used > limit означает, что лимит исчерпан.
+	// Счётчик сбрасывается при создании ссылки с тем же id или её замене через Put и удаляется вместе со ссылкой.
+	Redeem(ctx context.Context, id string) (used, limit int64, err error)
+	// Redemptions возвращает число засчитанных переходов по ссылке
+	Redemptions(ctx context.Context, id string) (int64, error)
+
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// Migrator реализуют хранилища, где могут остаться ссылки в устаревшем формате
+type Migrator interface {
+	MigrateLegacy(ctx context.Context, dryRun bool, onError func(id string, err error)) (MigrationResult, error)
+}
+
+type MigrationResult struct {
+	Scanned  int
+	Migrated int
+	Failed   int
+}
+
+const (
+	BackendRedis  = "redis"
+	BackendMemory = "memory"
+	BackendBolt   = "bolt"
+)
+
+// Config выбирает реализацию хранилища
+type Config struct {
+	Backend   string // redis (по умолчанию), memory или bolt
+	RedisAddr string
+	BoltPath  string
+	// Shared открывает файл bolt только на время операции, чтобы его могли делить несколько процессов
+	Shared   bool
+	ReadOnly bool
+}
+
+// Open создаёт хранилище по конфигурации
+func Open(cfg Config) (Store, error) {
+	switch cfg.Backend {
+	case "", BackendRedis:
+		return NewRedis(cfg.RedisAddr), nil
+	case BackendMemory:
+		return NewMemory(), nil
+	case BackendBolt:
+		return OpenBolt(cfg.BoltPath, BoltOptions{Shared: cfg.Shared, ReadOnly: cfg.ReadOnly})
+	default:
+		return nil, fmt.Errorf("unknown link store backend %q (expected %s, %s or %s)", cfg.Backend, BackendRedis, BackendMemory, BackendBolt)
+	}
+}
+
+// clicksKey - ключ счётчика переходов ссылки
+func clicksKey(id string) string {
+	return "clicks:" + id
+}
+
+// deadline переводит TTL в момент удаления записи; нулевое время - бессрочно
+func deadline(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
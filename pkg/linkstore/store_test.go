@@ -0,0 +1,564 @@
+package linkstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Контракт Store проверяется на всех хранилищах, которые можно поднять без внешних сервисов;
+// Redis заменяет miniredis. open возвращает хранилище и функцию, которая сдвигает его часы
+// (в miniredis сроки хранения не истекают сами).
+var backends = []struct {
+	name string
+	open func(t *testing.T) (Store, func(time.Duration))
+}{
+	{"memory", func(t *testing.T) (Store, func(time.Duration)) {
+		return NewMemory(), time.Sleep
+	}},
+	{"bolt", func(t *testing.T) (Store, func(time.Duration)) {
+		store, err := OpenBolt(filepath.Join(t.TempDir(), "links.db"), BoltOptions{})
+		if err != nil {
+			t.Fatalf("OpenBolt: %v", err)
+		}
+		return store, time.Sleep
+	}},
+	{"bolt-shared", func(t *testing.T) (Store, func(time.Duration)) {
+		store, err := OpenBolt(filepath.Join(t.TempDir(), "links.db"), BoltOptions{Shared: true})
+		if err != nil {
+			t.Fatalf("OpenBolt: %v", err)
+		}
+		return store, time.Sleep
+	}},
+	{"redis", func(t *testing.T) (Store, func(time.Duration)) {
+		server := miniredis.RunT(t)
+		return NewRedis(server.Addr()), server.FastForward
+	}},
+}
+
+func forEachBackend(t *testing.T, test func(t *testing.T, ctx context.Context, store Store)) {
+	forEachBackendClock(t, func(t *testing.T, ctx context.Context, store Store, _ func(time.Duration)) {
+		test(t, ctx, store)
+	})
+}
+
+func forEachBackendClock(t *testing.T, test func(t *testing.T, ctx context.Context, store Store, elapse func(time.Duration))) {
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			store, elapse := backend.open(t)
+			t.Cleanup(func() { store.Close() })
+			test(t, context.Background(), store, elapse)
+		})
+	}
+}
+
+func testRecord(url string, createdAt time.Time) *Record {
+	return &Record{URL: url, CreatedAt: createdAt.UTC().Truncate(time.Millisecond)}
+}
+
+func TestStoreCreate(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ctx context.Context, store Store) {
+		created, err := store.Create(ctx, "abc", testRecord("https://example.com/a", time.Now()), 0)
+		if err != nil || !created {
+			t.Fatalf("Create = %v, %v; want true", created, err)
+		}
+		created, err = store.Create(ctx, "abc", testRecord("https://example.com/b", time.Now()), 0)
+		if err != nil || created {
+			t.Fatalf("second Create = %v, %v; want false", created, err)
+		}
+
+		record, err := store.Get(ctx, "abc")
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if record.URL != "https://example.com/a" {
+			t.Errorf("URL = %q, the first Create must win", record.URL)
+		}
+		if record.Version != RecordVersion {
+			t.Errorf("Version = %d, want %d", record.Version, RecordVersion)
+		}
+
+		if _, err := store.Get(ctx, "missing"); err != ErrNotFound {
+			t.Errorf("Get(missing) error = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestStoreCreateMany(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ctx context.Context, store Store) {
+		if _, err := store.Create(ctx, "taken", testRecord("https://example.com/taken", time.Now()), 0); err != nil {
+			t.Fatal(err)
+		}
+
+		entries := []Entry{
+			{ID: "one", Record: testRecord("https://example.com/1", time.Now())},
+			{ID: "taken", Record: testRecord("https://example.com/2", time.Now())},
+			{ID: "brand.com/one", Record: testRecord("https://example.com/3", time.Now())},
+		}
+		created, err := store.CreateMany(ctx, entries)
+		if err != nil {
+			t.Fatalf("CreateMany: %v", err)
+		}
+		if want := []bool{true, false, true}; !reflect.DeepEqual(created, want) {
+			t.Fatalf("CreateMany = %v, want %v", created, want)
+		}
+
+		records, err := store.GetMany(ctx, []string{"brand.com/one", "missing", "taken"})
+		if err != nil {
+			t.Fatalf("GetMany: %v", err)
+		}
+		if records[0] == nil || records[0].URL != "https://example.com/3" {
+			t.Errorf("GetMany[0] = %+v, want the branded link", records[0])
+		}
+		if records[1] != nil {
+			t.Errorf("GetMany[1] = %+v, want nil", records[1])
+		}
+		if records[2] == nil || records[2].URL != "https://example.com/taken" {
+			t.Errorf("GetMany[2] = %+v, the existing link must not be replaced", records[2])
+		}
+	})
+}
+
+func TestStoreUpdatePutDelete(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ctx context.Context, store Store) {
+		if err := store.Update(ctx, "abc", testRecord("https://example.com", time.Now()), 0); err != ErrNotFound {
+			t.Fatalf("Update(missing) error = %v, want ErrNotFound", err)
+		}
+		if err := store.Put(ctx, "abc", testRecord("https://example.com/put", time.Now()), 0); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		if err := store.Update(ctx, "abc", testRecord("https://example.com/update", time.Now()), 0); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		if record, err := store.Get(ctx, "abc"); err != nil || record.URL != "https://example.com/update" {
+			t.Fatalf("Get after Update = %+v, %v", record, err)
+		}
+
+		if err := store.Delete(ctx, "abc"); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if _, err := store.Get(ctx, "abc"); err != ErrNotFound {
+			t.Errorf("Get after Delete error = %v, want ErrNotFound", err)
+		}
+		if err := store.Delete(ctx, "abc"); err != ErrNotFound {
+			t.Errorf("second Delete error = %v, want ErrNotFound", err)
+		}
+		created, err := store.Create(ctx, "abc", testRecord("https://example.com/again", time.Now()), 0)
+		if err != nil || !created {
+			t.Errorf("Create after Delete = %v, %v; want true", created, err)
+		}
+	})
+}
+
+func TestStoreTTL(t *testing.T) {
+	forEachBackendClock(t, func(t *testing.T, ctx context.Context, store Store, elapse func(time.Duration)) {
+		if _, err := store.Create(ctx, "short", testRecord("https://example.com", time.Now()), 50*time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Get(ctx, "short"); err != nil {
+			t.Fatalf("Get before TTL: %v", err)
+		}
+		elapse(100 * time.Millisecond)
+
+		if _, err := store.Get(ctx, "short"); err != ErrNotFound {
+			t.Errorf("Get after TTL error = %v, want ErrNotFound", err)
+		}
+		created, err := store.Create(ctx, "short", testRecord("https://example.com/new", time.Now()), 0)
+		if err != nil || !created {
+			t.Errorf("Create after TTL = %v, %v; the id must be free again", created, err)
+		}
+	})
+}
+
+func TestStoreRange(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ctx context.Context, store Store) {
+		base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+		var members []string
+		for i, id := range []string{"a", "b", "c", "d", "e"} {
+			record := testRecord("https://example.com/"+id, base.Add(time.Duration(i)*time.Second))
+			record.Owner = "team"
+			if i%2 == 0 {
+				record.Tags = []string{"even"}
+			}
+			if _, err := store.Create(ctx, id, record, 0); err != nil {
+				t.Fatal(err)
+			}
+			members = append(members, IndexMember(id, record.CreatedAt))
+		}
+
+		tests := []struct {
+			name      string
+			index     string
+			cursor    string
+			ascending bool
+			limit     int
+			want      []string
+		}{
+			{"first page ascending", CreatedIndex, "", true, 2, members[:2]},
+			{"after cursor ascending", CreatedIndex, members[1], true, 2, members[2:4]},
+			{"last page ascending", CreatedIndex, members[3], true, 10, members[4:]},
+			{"after the last member", CreatedIndex, members[4], true, 10, nil},
+			{"first page descending", CreatedIndex, "", false, 2, []string{members[4], members[3]}},
+			{"after cursor descending", CreatedIndex, members[3], false, 2, []string{members[2], members[1]}},
+			{"before the first member", CreatedIndex, members[0], false, 10, nil},
+			{"owner index", OwnerIndex("team"), "", true, 10, members},
+			{"tag index", TagIndex("even"), "", true, 10, []string{members[0], members[2], members[4]}},
+			{"unknown index", TagIndex("missing"), "", true, 10, nil},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				page, err := store.Range(ctx, tt.index, tt.cursor, tt.ascending, tt.limit)
+				if err != nil {
+					t.Fatalf("Range: %v", err)
+				}
+				if len(page) != len(tt.want) || (len(page) > 0 && !reflect.DeepEqual(page, tt.want)) {
+					t.Errorf("Range = %v, want %v", page, tt.want)
+				}
+			})
+		}
+	})
+}
+
+func TestStoreIndexesFollowWrites(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ctx context.Context, store Store) {
+		record := testRecord("https://example.com", time.Now())
+		record.Tags = []string{"old"}
+		if _, err := store.Create(ctx, "abc", record, 0); err != nil {
+			t.Fatal(err)
+		}
+		member := IndexMember("abc", record.CreatedAt)
+
+		record.Tags = []string{"new"}
+		if err := store.Update(ctx, "abc", record, 0); err != nil {
+			t.Fatal(err)
+		}
+		if page, _ := store.Range(ctx, TagIndex("old"), "", true, 10); len(page) != 0 {
+			t.Errorf("old tag index = %v, want empty after Update", page)
+		}
+		if page, _ := store.Range(ctx, TagIndex("new"), "", true, 10); !reflect.DeepEqual(page, []string{member}) {
+			t.Errorf("new tag index = %v, want [%s]", page, member)
+		}
+
+		if err := store.Delete(ctx, "abc"); err != nil {
+			t.Fatal(err)
+		}
+		if page, _ := store.Range(ctx, CreatedIndex, "", true, 10); len(page) != 0 {
+			t.Errorf("created index = %v, want empty after Delete", page)
+		}
+	})
+}
+
+func TestStoreRefs(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ctx context.Context, store Store) {
+		if _, err := store.GetRef(ctx, "ref"); err != ErrNotFound {
+			t.Fatalf("GetRef(missing) error = %v, want ErrNotFound", err)
+		}
+		if err := store.SetRef(ctx, "ref", "abc"); err != nil {
+			t.Fatal(err)
+		}
+
+		// DeleteRef не трогает указатель, который уже указывает на другое значение
+		if err := store.DeleteRef(ctx, "ref", "other"); err != nil {
+			t.Fatal(err)
+		}
+		if value, err := store.GetRef(ctx, "ref"); err != nil || value != "abc" {
+			t.Fatalf("GetRef = %q, %v; want abc", value, err)
+		}
+		if err := store.DeleteRef(ctx, "ref", "abc"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.GetRef(ctx, "ref"); err != ErrNotFound {
+			t.Errorf("GetRef after DeleteRef error = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestStoreSwapRef(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ctx context.Context, store Store) {
+		steps := []struct {
+			old, value string
+			swapped    bool
+			want       string
+		}{
+			{"", "v1", true, "v1"},
+			{"", "v2", false, "v1"},
+			{"stale", "v2", false, "v1"},
+			{"v1", "v2", true, "v2"},
+		}
+		for i, step := range steps {
+			swapped, err := store.SwapRef(ctx, "doc", step.old, step.value)
+			if err != nil {
+				t.Fatalf("step %d: SwapRef: %v", i, err)
+			}
+			if swapped != step.swapped {
+				t.Errorf("step %d: SwapRef(%q, %q) = %v, want %v", i, step.old, step.value, swapped, step.swapped)
+			}
+			if value, _ := store.GetRef(ctx, "doc"); value != step.want {
+				t.Errorf("step %d: value = %q, want %q", i, value, step.want)
+			}
+		}
+	})
+}
+
+func TestStoreIncr(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ctx context.Context, store Store) {
+		for want := int64(1); want <= 3; want++ {
+			if got, err := store.Incr(ctx, "counter"); err != nil || got != want {
+				t.Fatalf("Incr = %d, %v; want %d", got, err, want)
+			}
+		}
+	})
+}
+
+func TestStoreRedeem(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ctx context.Context, store Store) {
+		if _, _, err := store.Redeem(ctx, "missing"); err != ErrNotFound {
+			t.Fatalf("Redeem(missing) error = %v, want ErrNotFound", err)
+		}
+
+		if _, err := store.Create(ctx, "open", testRecord("https://example.com", time.Now()), 0); err != nil {
+			t.Fatal(err)
+		}
+		if used, limit, err := store.Redeem(ctx, "open"); err != nil || used != 0 || limit != 0 {
+			t.Errorf("Redeem(unlimited) = %d, %d, %v; want 0, 0", used, limit, err)
+		}
+
+		limited := testRecord("https://example.com", time.Now())
+		limited.MaxClicks = 2
+		if _, err := store.Create(ctx, "once", limited, 0); err != nil {
+			t.Fatal(err)
+		}
+		for want := int64(1); want <= 3; want++ {
+			used, limit, err := store.Redeem(ctx, "once")
+			if err != nil || used != want || limit != 2 {
+				t.Fatalf("Redeem #%d = %d, %d, %v; want %d, 2", want, used, limit, err, want)
+			}
+		}
+		if used, err := store.Redemptions(ctx, "once"); err != nil || used != 3 {
+			t.Errorf("Redemptions = %d, %v; want 3", used, err)
+		}
+
+		// Update сохраняет счётчик, Put заменяет ссылку вместе с ним
+		if err := store.Update(ctx, "once", limited, 0); err != nil {
+			t.Fatal(err)
+		}
+		if used, _ := store.Redemptions(ctx, "once"); used != 3 {
+			t.Errorf("Redemptions after Update = %d, want 3", used)
+		}
+		if err := store.Put(ctx, "once", limited, 0); err != nil {
+			t.Fatal(err)
+		}
+		if used, _ := store.Redemptions(ctx, "once"); used != 0 {
+			t.Errorf("Redemptions after Put = %d, want 0", used)
+		}
+
+		// Новая ссылка с тем же id начинает счёт заново
+		store.Redeem(ctx, "once")
+		if err := store.Delete(ctx, "once"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Create(ctx, "once", limited, 0); err != nil {
+			t.Fatal(err)
+		}
+		if used, _ := store.Redemptions(ctx, "once"); used != 0 {
+			t.Errorf("Redemptions after re-create = %d, want 0", used)
+		}
+	})
+}
+
+func TestStoreScan(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ctx context.Context, store Store) {
+		want := map[string]string{"a": "https://example.com/a", "brand.com/b": "https://example.com/b"}
+		for id, url := range want {
+			if _, err := store.Create(ctx, id, testRecord(url, time.Now()), 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		got := map[string]string{}
+		err := store.Scan(ctx, func(id string, record *Record) error {
+			got[id] = record.URL
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Scan = %v, want %v", got, want)
+		}
+	})
+}
+
+// Параллельные Update и Delete не должны оставлять элементы индексов без ссылки
+func TestStoreConcurrentUpdateDelete(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ctx context.Context, store Store) {
+		for round := 0; round < 20; round++ {
+			record := testRecord("https://example.com", time.Now())
+			if err := store.Put(ctx, "abc", record, 0); err != nil {
+				t.Fatal(err)
+			}
+
+			var wg sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					updated := *record
+					updated.Tags = []string{fmt.Sprintf("tag%d", i)}
+					if err := store.Update(ctx, "abc", &updated, 0); err != nil && err != ErrNotFound {
+						t.Errorf("Update: %v", err)
+					}
+				}(i)
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := store.Delete(ctx, "abc"); err != nil && err != ErrNotFound {
+					t.Errorf("Delete: %v", err)
+				}
+			}()
+			wg.Wait()
+
+			// Ссылка могла пережить удаление, если Update пришёл после него, но тогда
+			// в индексах только её текущий тег
+			current, err := store.Get(ctx, "abc")
+			if err != nil && err != ErrNotFound {
+				t.Fatal(err)
+			}
+			for i := 0; i < 4; i++ {
+				tag := fmt.Sprintf("tag%d", i)
+				page, _ := store.Range(ctx, TagIndex(tag), "", true, 10)
+				indexed := len(page) > 0
+				wanted := current != nil && len(current.Tags) == 1 && current.Tags[0] == tag
+				if indexed != wanted {
+					t.Fatalf("round %d: tag %s indexed = %v, link = %+v", round, tag, indexed, current)
+				}
+			}
+			if current != nil {
+				if err := store.Delete(ctx, "abc"); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
+// Scan обходит больше одной пачки и вызывает fn вне транзакции: колбэк может писать в хранилище
+func TestStoreScanCallbackWrites(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ctx context.Context, store Store) {
+		const links = 1234
+		entries := make([]Entry, links)
+		for i := range entries {
+			entries[i] = Entry{ID: fmt.Sprintf("code%04d", i), Record: testRecord("https://example.com", time.Now())}
+		}
+		if _, err := store.CreateMany(ctx, entries); err != nil {
+			t.Fatal(err)
+		}
+
+		seen := map[string]bool{}
+		err := store.Scan(ctx, func(id string, record *Record) error {
+			if seen[id] {
+				t.Errorf("Scan visited %s twice", id)
+			}
+			seen[id] = true
+			if len(seen)%300 == 0 {
+				return store.SetRef(ctx, "scan:"+id, id)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+		if len(seen) != links {
+			t.Errorf("Scan visited %d links, want %d", len(seen), links)
+		}
+	})
+}
+
+// corruptRecord записывает под id значение, которое хранилище не сможет разобрать
+func corruptRecord(t *testing.T, ctx context.Context, store Store, id string) {
+	t.Helper()
+	var err error
+	switch s := store.(type) {
+	case *MemoryStore:
+		s.mu.Lock()
+		s.links[id] = memoryEntry{value: "{broken"}
+		s.mu.Unlock()
+	case *BoltStore:
+		err = s.update(func(tx *bolt.Tx) error {
+			return tx.Bucket(linksBucket).Put([]byte(id), []byte("{broken"))
+		})
+	case *RedisStore:
+		err = s.client.Set(ctx, "url:"+id, "{broken", 0).Err()
+	default:
+		t.Fatalf("unknown store %T", store)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Нечитаемая запись не должна выглядеть удалённой: её нельзя терять из индексов и занимать заново
+func TestStoreUnreadableRecord(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ctx context.Context, store Store) {
+		record := testRecord("https://example.com", time.Now())
+		if _, err := store.Create(ctx, "bad", record, 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Create(ctx, "good", record, 0); err != nil {
+			t.Fatal(err)
+		}
+		corruptRecord(t, ctx, store, "bad")
+
+		var unreadable *UnreadableError
+		if _, err := store.Get(ctx, "bad"); !errors.As(err, &unreadable) {
+			t.Errorf("Get error = %v, want *UnreadableError", err)
+		}
+
+		records, err := store.GetMany(ctx, []string{"good", "bad", "missing"})
+		if !errors.As(err, &unreadable) || !reflect.DeepEqual(unreadable.IDs, []string{"bad"}) {
+			t.Fatalf("GetMany error = %v, want *UnreadableError for bad", err)
+		}
+		if records[0] == nil || records[1] != nil || records[2] != nil {
+			t.Errorf("GetMany = %+v, want only the readable link", records)
+		}
+
+		if created, err := store.Create(ctx, "bad", record, 0); err != nil || created {
+			t.Errorf("Create over an unreadable record = %v, %v; want false", created, err)
+		}
+		if err := store.Unindex(ctx, "unrelated", IndexMember("bad", record.CreatedAt)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Get(ctx, "bad"); !errors.As(err, &unreadable) {
+			t.Errorf("Get after Unindex error = %v, the record must survive", err)
+		}
+
+		// Scan пропускает нечитаемую запись, но не прерывается
+		var scanned []string
+		if err := store.Scan(ctx, func(id string, record *Record) error {
+			scanned = append(scanned, id)
+			return nil
+		}); err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+		if !reflect.DeepEqual(scanned, []string{"good"}) {
+			t.Errorf("Scan = %v, want [good]", scanned)
+		}
+
+		// Put заменяет нечитаемую запись
+		if err := store.Put(ctx, "bad", record, 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Get(ctx, "bad"); err != nil {
+			t.Errorf("Get after Put: %v", err)
+		}
+	})
+}
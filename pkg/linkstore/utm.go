@@ -0,0 +1,119 @@
+package linkstore
+
+import (
+	"net/url"
+	"strings"
+)
+
+// UTM - метки кампании, которые shortener-service добавляет в адрес перехода параметрами utm_*
+type UTM struct {
+	Source   string `json:"source,omitempty"`
+	Medium   string `json:"medium,omitempty"`
+	Campaign string `json:"campaign,omitempty"`
+	Term     string `json:"term,omitempty"`
+	Content  string `json:"content,omitempty"`
+}
+
+// UTMField - метка кампании: имя поля в JSON и параметр адреса
+type UTMField struct {
+	Name  string
+	Param string
+}
+
+// UTMFields перечисляет метки в порядке, в котором они добавляются в адрес
+var UTMFields = []UTMField{
+	{Name: "source", Param: "utm_source"},
+	{Name: "medium", Param: "utm_medium"},
+	{Name: "campaign", Param: "utm_campaign"},
+	{Name: "term", Param: "utm_term"},
+	{Name: "content", Param: "utm_content"},
+}
+
+// Get возвращает значение метки по имени поля (см. UTMFields)
+func (u *UTM) Get(name string) string {
+	if p := u.field(name); p != nil {
+		return *p
+	}
+	return ""
+}
+
+// Set задаёт значение метки по имени поля (см. UTMFields)
+func (u *UTM) Set(name, value string) {
+	if p := u.field(name); p != nil {
+		*p = value
+	}
+}
+
+func (u *UTM) field(name string) *string {
+	switch name {
+	case "source":
+		return &u.Source
+	case "medium":
+		return &u.Medium
+	case "campaign":
+		return &u.Campaign
+	case "term":
+		return &u.Term
+	case "content":
+		return &u.Content
+	}
+	return nil
+}
+
+// IsZero сообщает, что не задана ни одна метка
+func (u UTM) IsZero() bool {
+	return u == UTM{}
+}
+
+// Override возвращает метки u, заменённые непустыми метками other
+func (u UTM) Override(other UTM) UTM {
+	for _, f := range UTMFields {
+		if value := other.Get(f.Name); value != "" {
+			u.Set(f.Name, value)
+		}
+	}
+	return u
+}
+
+// ParseUTM читает метки кампании из параметров адреса
+func ParseUTM(query url.Values) UTM {
+	var u UTM
+	for _, f := range UTMFields {
+		u.Set(f.Name, query.Get(f.Param))
+	}
+	return u
+}
+
+// ApplyUTM записывает непустые метки в адрес: одноимённые параметры адреса заменяются,
+// остальные параметры остаются в исходном виде и порядке.
+func ApplyUTM(destination string, u UTM) (string, error) {
+	if u.IsZero() {
+		return destination, nil
+	}
+	parsed, err := url.Parse(destination)
+	if err != nil {
+		return "", err
+	}
+
+	replaced := make(map[string]bool, len(UTMFields))
+	var added []string
+	for _, f := range UTMFields {
+		if value := u.Get(f.Name); value != "" {
+			replaced[f.Param] = true
+			added = append(added, f.Param+"="+url.QueryEscape(value))
+		}
+	}
+
+	var pairs []string
+	for _, pair := range strings.Split(parsed.RawQuery, "&") {
+		name, _, _ := strings.Cut(pair, "=")
+		if decoded, err := url.QueryUnescape(name); err == nil {
+			name = decoded
+		}
+		if pair != "" && !replaced[name] {
+			pairs = append(pairs, pair)
+		}
+	}
+	parsed.RawQuery = strings.Join(append(pairs, added...), "&")
+	return parsed.String(), nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/itcaat/url-shortener-demo/pkg/linkstore"
+)
+
+// Признаки ботов и сервисов предпросмотра ссылок в User-Agent (в нижнем регистре)
+var botMarkers = []string{
+	"bot", "crawler", "spider", "slurp", "facebookexternalhit", "embedly", "preview",
+	"curl/", "wget/", "python-requests", "go-http-client", "headlesschrome",
+}
+
+// clientDevice - платформа и тип устройства посетителя; пустая платформа - не удалось определить
+type clientDevice struct {
+	Platform string
+	Device   string
+}
+
+// detectDevice определяет платформу и тип устройства по User-Agent. Разбор эвристический:
+// его хватает для выбора магазина приложений, но не для точной статистики по версиям.
+func detectDevice(userAgent string) clientDevice {
+	ua := strings.ToLower(userAgent)
+	for _, marker := range botMarkers {
+		if strings.Contains(ua, marker) {
+			return clientDevice{Device: linkstore.DeviceBot}
+		}
+	}
+
+	switch {
+	case strings.Contains(ua, "ipad"):
+		return clientDevice{Platform: linkstore.PlatformIOS, Device: linkstore.DeviceTablet}
+	case strings.Contains(ua, "iphone"), strings.Contains(ua, "ipod"):
+		return clientDevice{Platform: linkstore.PlatformIOS, Device: linkstore.DeviceMobile}
+	case strings.Contains(ua, "android"):
+		// Планшеты на Android не добавляют "Mobile" в User-Agent
+		if strings.Contains(ua, "mobile") {
+			return clientDevice{Platform: linkstore.PlatformAndroid, Device: linkstore.DeviceMobile}
+		}
+		return clientDevice{Platform: linkstore.PlatformAndroid, Device: linkstore.DeviceTablet}
+	case strings.Contains(ua, "cros"):
+		return clientDevice{Platform: linkstore.PlatformChromeOS, Device: linkstore.DeviceDesktop}
+	case strings.Contains(ua, "windows"):
+		return clientDevice{Platform: linkstore.PlatformWindows, Device: linkstore.DeviceDesktop}
+	case strings.Contains(ua, "macintosh"), strings.Contains(ua, "mac os x"):
+		// iPadOS по умолчанию представляется Mac, но с сенсорным Safari добавляет "Mobile/"
+		if strings.Contains(ua, "mobile/") {
+			return clientDevice{Platform: linkstore.PlatformIOS, Device: linkstore.DeviceTablet}
+		}
+		return clientDevice{Platform: linkstore.PlatformMacOS, Device: linkstore.DeviceDesktop}
+	case strings.Contains(ua, "linux"), strings.Contains(ua, "x11"):
+		return clientDevice{Platform: linkstore.PlatformLinux, Device: linkstore.DeviceDesktop}
+	default:
+		return clientDevice{Device: linkstore.DeviceDesktop}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Хосты брендированных доменов: у каждого своё пространство кодов
+	brandedHosts = map[string]bool{}
+
+	// Пространства кодов без учёта регистра ("" - домен по умолчанию): коды в них хранятся в нижнем регистре
+	caseInsensitiveScopes = map[string]bool{}
+)
+
+// initDomains читает PUBLIC_BASE_URL, BRANDED_DOMAINS (тот же формат, что у shortener-service:
+// базовые URL или хосты) и CODE_CASE_INSENSITIVE - регистр кодов по умолчанию
+func initDomains() {
+	caseInsensitive, _ := strconv.ParseBool(getEnv("CODE_CASE_INSENSITIVE", "false"))
+	caseInsensitiveScopes[""] = caseInsensitiveParam(getEnv("PUBLIC_BASE_URL", ""), caseInsensitive)
+
+	for _, entry := range strings.Split(getEnv("BRANDED_DOMAINS", ""), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		host := entry
+		if u, err := url.Parse(entry); err == nil && u.Host != "" {
+			host = u.Hostname()
+		}
+		host = strings.ToLower(host)
+		brandedHosts[host] = true
+		caseInsensitiveScopes[host] = caseInsensitiveParam(entry, caseInsensitive)
+	}
+	log.Printf("[Redirect Service] Branded domains: %d\n", len(brandedHosts))
+}
+
+// domainScope определяет пространство кодов по заголовку Host; неизвестные хосты - домен по умолчанию
+func domainScope(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	if brandedHosts[host] {
+		return host
+	}
+	return ""
+}
+
+// caseInsensitiveParam читает параметр caseInsensitive из базового URL домена
+func caseInsensitiveParam(baseURL string, fallback bool) bool {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return fallback
+	}
+	if value, err := strconv.ParseBool(u.Query().Get("caseInsensitive")); err == nil {
+		return value
+	}
+	return fallback
+}
+
+// canonicalCode приводит код к регистру, в котором он хранится в пространстве домена
+func canonicalCode(scope, code string) string {
+	if caseInsensitiveScopes[scope] {
+		return strings.ToLower(code)
+	}
+	return code
+}
+
+// linkID возвращает идентификатор ссылки с учётом домена: "code" или "domain/code"
+func linkID(scope, code string) string {
+	if scope == "" {
+		return code
+	}
+	return scope + "/" + code
+}
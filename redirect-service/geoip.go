@@ -0,0 +1,115 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// geoRecord - нужная часть записи базы в формате MaxMind (GeoLite2/GeoIP2 Country и City, DB-IP)
+type geoRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	// Страна регистрации сети - для адресов, у которых страна не определена (например, anycast)
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+// geoDatabase - база GeoIP, которую можно заменить на лету. Файл читается в память целиком:
+// старый экземпляр не закрывается явно, поэтому поиск, начатый до перезагрузки, безопасно завершится.
+type geoDatabase struct {
+	path   string
+	reader atomic.Pointer[maxminddb.Reader]
+
+	// Время изменения и размер загруженного файла, чтобы заметить его замену
+	modTime time.Time
+	size    int64
+}
+
+var geoIP *geoDatabase
+
+// initGeoIP загружает базу из GEOIP_DB_PATH и раз в GEOIP_RELOAD_INTERVAL проверяет, не изменился ли файл.
+// Без GEOIP_DB_PATH страна посетителя не определяется и правила по странам не срабатывают.
+func initGeoIP() {
+	path := getEnv("GEOIP_DB_PATH", "")
+	if path == "" {
+		log.Println("[Redirect Service] ℹ️  GeoIP disabled (GEOIP_DB_PATH not set)")
+		return
+	}
+
+	geoIP = &geoDatabase{path: path}
+	if err := geoIP.reload(); err != nil {
+		// Файл может появиться позже, например после первой загрузки обновления
+		log.Printf("[Redirect Service] ⚠️  Failed to load GeoIP database: %v\n", err)
+	}
+	go geoIP.watch(getEnvDuration("GEOIP_RELOAD_INTERVAL", time.Minute))
+}
+
+// reload перечитывает файл базы, если он изменился с последней загрузки
+func (db *geoDatabase) reload() error {
+	info, err := os.Stat(db.path)
+	if err != nil {
+		return err
+	}
+	if db.reader.Load() != nil && info.ModTime().Equal(db.modTime) && info.Size() == db.size {
+		return nil
+	}
+
+	data, err := os.ReadFile(db.path)
+	if err != nil {
+		return err
+	}
+	reader, err := maxminddb.FromBytes(data)
+	if err != nil {
+		return err
+	}
+	db.reader.Store(reader)
+	db.modTime, db.size = info.ModTime(), info.Size()
+
+	log.Printf("[Redirect Service] GeoIP database loaded: %s (%s, built %s)\n", db.path,
+		reader.Metadata.DatabaseType, time.Unix(int64(reader.Metadata.BuildEpoch), 0).UTC().Format("2006-01-02"))
+	return nil
+}
+
+// watch перезагружает базу при изменении файла; пока новый файл не читается, работает прежняя база
+func (db *geoDatabase) watch(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := db.reload(); err != nil {
+			log.Printf("[Redirect Service] ⚠️  Failed to reload GeoIP database: %v\n", err)
+		}
+	}
+}
+
+// lookupCountry возвращает код страны ISO 3166-1 alpha-2 для IP-адреса или "", если его не определить
+func lookupCountry(ip string) string {
+	if geoIP == nil {
+		return ""
+	}
+	reader := geoIP.reader.Load()
+	parsed := net.ParseIP(ip)
+	if reader == nil || parsed == nil {
+		return ""
+	}
+
+	var record geoRecord
+	if err := reader.Lookup(parsed, &record); err != nil {
+		log.Printf("[Redirect Service] GeoIP lookup failed for %s: %v\n", ip, err)
+		return ""
+	}
+	if record.Country.ISOCode != "" {
+		return strings.ToUpper(record.Country.ISOCode)
+	}
+	return strings.ToUpper(record.RegisteredCountry.ISOCode)
+}
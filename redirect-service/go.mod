@@ -3,15 +3,19 @@ module redirect-service
 go 1.21
 
 require (
-	github.com/go-redis/redis/v8 v8.11.5
 	github.com/gorilla/mux v1.8.1
+	github.com/itcaat/url-shortener-demo/pkg/linkstore v0.0.0
 	github.com/itcaat/url-shortener-demo/pkg/tracing v0.0.0
+	github.com/oschwald/maxminddb-golang v1.12.0
 	github.com/rs/cors v1.10.1
 	github.com/segmentio/kafka-go v0.4.47
 	go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux v0.46.1
 )
 
-replace github.com/itcaat/url-shortener-demo/pkg/tracing => ../pkg/tracing
+replace (
+	github.com/itcaat/url-shortener-demo/pkg/linkstore => ../pkg/linkstore
+	github.com/itcaat/url-shortener-demo/pkg/tracing => ../pkg/tracing
+)
 
 require (
 	github.com/cespare/xxhash/v2 v2.1.2 // indirect
@@ -19,12 +23,15 @@ require (
 	github.com/felixge/httpsnoop v1.0.4 // indirect
 	github.com/go-logr/logr v1.3.0 // indirect
 	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/go-redis/redis/v8 v8.11.5 // indirect
 	github.com/klauspost/compress v1.15.9 // indirect
 	github.com/pierrec/lz4/v4 v4.1.15 // indirect
+	go.etcd.io/bbolt v1.3.8 // indirect
 	go.opentelemetry.io/otel v1.21.0 // indirect
 	go.opentelemetry.io/otel/exporters/jaeger v1.17.0 // indirect
 	go.opentelemetry.io/otel/metric v1.21.0 // indirect
 	go.opentelemetry.io/otel/sdk v1.21.0 // indirect
 	go.opentelemetry.io/otel/trace v1.21.0 // indirect
+	golang.org/x/crypto v0.14.0 // indirect
 	golang.org/x/sys v0.14.0 // indirect
 )
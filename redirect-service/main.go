@@ -4,15 +4,18 @@ import (
 	"context"
 	"encoding/json"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
-	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/mux"
+	"github.com/itcaat/url-shortener-demo/pkg/linkstore"
 	"github.com/itcaat/url-shortener-demo/pkg/tracing"
 	"github.com/rs/cors"
 	"github.com/segmentio/kafka-go"
@@ -20,10 +23,16 @@ import (
 )
 
 var (
-	redisClient *redis.Client
+	store       linkstore.Store
 	kafkaWriter *kafka.Writer
 	ctx         = context.Background()
 	port        = getEnv("PORT", "3002")
+
+	// Адреса и подсети прокси, которым можно доверить X-Forwarded-For и X-Real-IP (TRUSTED_PROXIES)
+	trustedProxies []*net.IPNet
+
+	// Отправка события перехода; тесты подменяют её, чтобы не ходить в Kafka
+	publishClick = publishClickEvent
 )
 
 type HealthResponse struct {
@@ -34,9 +43,25 @@ type HealthResponse struct {
 
 type ClickEvent struct {
 	ShortCode string    `json:"shortCode"`
+	Domain    string    `json:"domain,omitempty"`
 	Timestamp time.Time `json:"timestamp"`
 	UserAgent string    `json:"userAgent"`
 	IP        string    `json:"ip"`
+	// Переход исчерпал лимит maxClicks: больше ссылка не откроется
+	Exhausted bool `json:"exhausted,omitempty"`
+	// Устройство и страна посетителя и ветка правил ссылки, по которой он ушёл
+	Platform string `json:"platform,omitempty"`
+	Device   string `json:"device,omitempty"`
+	Country  string `json:"country,omitempty"`
+	Route    string `json:"route,omitempty"`
+	// Вариант A/B-теста, в который попал посетитель
+	Variant string `json:"variant,omitempty"`
+	// Метки кампании utm_* итогового адреса перехода
+	UTMSource   string `json:"utmSource,omitempty"`
+	UTMMedium   string `json:"utmMedium,omitempty"`
+	UTMCampaign string `json:"utmCampaign,omitempty"`
+	UTMTerm     string `json:"utmTerm,omitempty"`
+	UTMContent  string `json:"utmContent,omitempty"`
 }
 
 func main() {
@@ -54,35 +79,24 @@ func main() {
 		log.Println("[Redirect Service] ℹ️  Distributed tracing disabled (JAEGER_AGENT_HOST not set)")
 	}
 
-	initRedis()
+	initDomains()
+	initTrustedProxies()
+	initPasswords()
+	initGeoIP()
+	initStore()
+	defer store.Close()
 	initKafka()
 	defer kafkaWriter.Close()
 
-	router := mux.NewRouter()
-
-	// Add OpenTelemetry middleware
-	router.Use(otelmux.Middleware("redirect-service"))
-
-	router.HandleFunc("/health", healthHandler).Methods("GET")
-	router.HandleFunc("/{shortCode}", redirectHandler).Methods("GET")
-
-	handler := cors.New(cors.Options{
-		AllowedOrigins: []string{"*"},
-		AllowedMethods: []string{"GET", "OPTIONS"},
-		AllowedHeaders: []string{"*"},
-	}).Handler(router)
-
 	// Graceful shutdown
 	server := &http.Server{
 		Addr:    ":" + port,
-		Handler: handler,
+		Handler: newHandler(),
 	}
 
 	go func() {
 		log.Printf("[Redirect Service] Server starting on port %s\n", port)
-		log.Printf("[Redirect Service] Connected to Redis at %s:%s\n",
-			getEnv("REDIS_HOST", "localhost"),
-			getEnv("REDIS_PORT", "6379"))
+		log.Printf("[Redirect Service] Using %s link store\n", getEnv("LINK_STORE", linkstore.BackendRedis))
 		log.Printf("[Redirect Service] Connected to Kafka at %s, topic: %s\n",
 			getEnv("KAFKA_BROKERS", "localhost:9092"),
 			getEnv("KAFKA_TOPIC", "url-clicks"))
@@ -106,19 +120,48 @@ func main() {
 	log.Println("[Redirect Service] Server exited")
 }
 
-func initRedis() {
-	redisHost := getEnv("REDIS_HOST", "localhost")
-	redisPort := getEnv("REDIS_PORT", "6379")
+// newHandler собирает маршруты сервиса с трассировкой и CORS
+func newHandler() http.Handler {
+	router := mux.NewRouter()
 
-	redisClient = redis.NewClient(&redis.Options{
-		Addr:     redisHost + ":" + redisPort,
-		Password: "",
-		DB:       0,
-	})
+	// Add OpenTelemetry middleware
+	router.Use(otelmux.Middleware("redirect-service"))
 
-	_, err := redisClient.Ping(ctx).Result()
+	router.HandleFunc("/health", healthHandler).Methods("GET")
+	router.HandleFunc("/{shortCode}", redirectHandler).Methods("GET")
+	router.HandleFunc("/{shortCode}", unlockHandler).Methods("POST")
+	router.HandleFunc("/{shortCode}", methodRedirectHandler).Methods(preservedMethods...)
+	// Путь после кода передаётся в адрес перехода, если это разрешено в ссылке
+	router.HandleFunc("/{shortCode}/{path:.*}", redirectHandler).Methods("GET")
+	router.HandleFunc("/{shortCode}/{path:.*}", unlockHandler).Methods("POST")
+	router.HandleFunc("/{shortCode}/{path:.*}", methodRedirectHandler).Methods(preservedMethods...)
+
+	return cors.New(cors.Options{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: append([]string{"GET", "POST", "OPTIONS"}, preservedMethods...),
+		AllowedHeaders: []string{"*"},
+	}).Handler(router)
+}
+
+// initStore открывает хранилище ссылок (LINK_STORE: redis, memory или bolt). Сам сервис пишет
+// только счётчики переходов (см. Store.Redeem), но файл bolt пишет и shortener-service,
+// поэтому его можно делить только в режиме LINK_STORE_SHARED.
+func initStore() {
+	shared, _ := strconv.ParseBool(getEnv("LINK_STORE_SHARED", "false"))
+
+	var err error
+	store, err = linkstore.Open(linkstore.Config{
+		Backend:   getEnv("LINK_STORE", linkstore.BackendRedis),
+		RedisAddr: getEnv("REDIS_HOST", "localhost") + ":" + getEnv("REDIS_PORT", "6379"),
+		BoltPath:  getEnv("LINK_STORE_PATH", "links.db"),
+		Shared:    shared,
+	})
 	if err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+		log.Fatalf("Failed to open link store: %v", err)
+	}
+
+	if err := store.Ping(ctx); err != nil {
+		log.Fatalf("Failed to connect to link store: %v", err)
 	}
 }
 
@@ -139,7 +182,7 @@ func initKafka() {
 }
 
 func healthHandler(w http.ResponseWriter, r *http.Request) {
-	_, err := redisClient.Ping(ctx).Result()
+	err := store.Ping(ctx)
 	status := "healthy"
 	if err != nil {
 		status = "unhealthy"
@@ -153,38 +196,240 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, response)
 }
 
-func redirectHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	shortCode := vars["shortCode"]
+// shortLink - ссылка из пути запроса, доступная для перехода
+type shortLink struct {
+	scope  string
+	code   string
+	id     string
+	record *linkstore.Record
+	// Путь после кода в экранированном виде (см. trailingPath)
+	path string
+}
 
-	// Получение оригинального URL из Redis
-	originalURL, err := redisClient.Get(ctx, "url:"+shortCode).Result()
-	if err == redis.Nil {
-		log.Printf("[Redirect Service] Short code '%s' not found\n", shortCode)
+// loadShortLink находит ссылку по коду из пути и заголовку Host и отвечает ошибкой,
+// если её нет, она отключена или истекла
+func loadShortLink(w http.ResponseWriter, r *http.Request) (*shortLink, bool) {
+	vars := mux.Vars(r)
+	scope := domainScope(r)
+	shortCode := canonicalCode(scope, vars["shortCode"])
+	id := linkID(scope, shortCode)
+
+	// Получение записи ссылки за один запрос (хранилище само читает и устаревший формат)
+	record, err := store.Get(ctx, id)
+	if err == linkstore.ErrNotFound {
+		log.Printf("[Redirect Service] Short code '%s' not found\n", id)
 		http.Error(w, "Short URL not found", http.StatusNotFound)
-		return
+		return nil, false
 	} else if err != nil {
-		log.Printf("[Redirect Service] Redis error: %v\n", err)
+		log.Printf("[Redirect Service] Store error: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if record.URL == "" {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if record.State == linkstore.StateDisabled {
+		log.Printf("[Redirect Service] Short code '%s' is disabled\n", id)
+		http.Error(w, "Short URL is disabled", http.StatusGone)
+		return nil, false
+	}
+
+	if record.ExpiresAt != nil && !time.Now().Before(*record.ExpiresAt) {
+		log.Printf("[Redirect Service] Short code '%s' expired at %s\n", id, record.ExpiresAt.Format(time.RFC3339))
+		http.Error(w, "Short URL has expired", http.StatusGone)
+		return nil, false
+	}
+
+	// Путь после кода есть только у ссылок, которые его передают
+	path, hasPath := trailingPath(r)
+	if hasPath && (!record.PathPassthrough || !validTrailingPath(path)) {
+		log.Printf("[Redirect Service] Short code '%s' does not accept path '%s'\n", id, path)
+		http.Error(w, "Short URL not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return &shortLink{scope: scope, code: shortCode, id: id, record: record, path: path}, true
+}
+
+func redirectHandler(w http.ResponseWriter, r *http.Request) {
+	link, ok := loadShortLink(w, r)
+	if !ok {
 		return
 	}
+	serveRedirect(w, r, link)
+}
 
-	// Асинхронная отправка события в Kafka
-	go publishClickEvent(shortCode, r)
+// serveRedirect проверяет доступ к ссылке, засчитывает переход и перенаправляет посетителя
+// с кодом ссылки (см. redirectStatus)
+func serveRedirect(w http.ResponseWriter, r *http.Request, link *shortLink) {
+	id := link.id
+
+	if !withinActiveWindow(w, r, link) {
+		return
+	}
 
-	log.Printf("[Redirect Service] Redirecting '%s' to %s\n", shortCode, originalURL)
+	// Защищённая ссылка открывается только с cookie, выданной после ввода пароля
+	if link.record.PasswordHash != "" && !hasLinkAccess(r, link) {
+		renderPasswordForm(w, http.StatusOK, "")
+		return
+	}
+
+	event := newClickEvent(link, r)
+
+	// Боты, раскрывающие превью ссылок в мессенджерах, не должны тратить переходы ссылки с лимитом.
+	// Адрес им не показывается: User-Agent легко подделать, и иначе лимит обходился бы подменой заголовка.
+	if link.record.MaxClicks > 0 && event.Device == linkstore.DeviceBot {
+		serveBotPlaceholder(w, link)
+		return
+	}
+
+	// Переход по ссылке с лимитом засчитывается атомарно с чтением записи в хранилище
+	if link.record.MaxClicks > 0 {
+		used, limit, err := store.Redeem(ctx, id)
+		if err == linkstore.ErrNotFound {
+			http.Error(w, "Short URL not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("[Redirect Service] Store error: %v\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if limit > 0 && used > limit {
+			log.Printf("[Redirect Service] Short code '%s' reached its click limit (%d)\n", id, limit)
+			http.Error(w, "Short URL has reached its click limit", http.StatusGone)
+			return
+		}
+		if limit > 0 && used == limit {
+			log.Printf("[Redirect Service] Short code '%s' used its last allowed click\n", id)
+			event.Exhausted = true
+		}
+	}
+
+	// Адрес выбирается по правилам ссылки для устройства и страны посетителя и времени перехода
+	originalURL, route, matched := link.record.Route(linkstore.Visitor{
+		Platform: event.Platform,
+		Device:   event.Device,
+		Country:  event.Country,
+		Time:     event.Timestamp,
+	})
+	event.Route = route
+	if route != "" {
+		// Ответ зависит от устройства, кеши не должны отдавать его другим посетителям
+		w.Header().Add("Vary", "User-Agent")
+	}
+
+	// Посетители без подходящего правила делятся между вариантами A/B-теста
+	if !matched {
+		if variant, ok := pickVariant(w, r, link); ok {
+			originalURL = variant.URL
+			event.Variant = variant.Name
+			w.Header().Add("Vary", "Cookie")
+		}
+	}
+
+	originalURL = passthroughDestination(originalURL, link, r)
+	event.setCampaign(originalURL)
+
+	// Асинхронная отправка события в Kafka
+	go publishClick(event)
+
+	switch {
+	case event.Variant != "":
+		log.Printf("[Redirect Service] Redirecting '%s' to %s (variant '%s')\n", id, originalURL, event.Variant)
+	case route != "":
+		log.Printf("[Redirect Service] Redirecting '%s' to %s (route '%s')\n", id, originalURL, route)
+	default:
+		log.Printf("[Redirect Service] Redirecting '%s' to %s\n", id, originalURL)
+	}
 
 	// Перенаправление
-	http.Redirect(w, r, originalURL, http.StatusFound)
+	status := redirectStatus(link.record)
+	w.Header().Set("Cache-Control", redirectCacheControl(link.record, status, event.Timestamp))
+	http.Redirect(w, r, originalURL, status)
+}
+
+// botPlaceholder - страница для ботов вместо перехода по ссылке с лимитом
+const botPlaceholder = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="robots" content="noindex"><title>Short link</title></head>
+<body><p>This link can be opened a limited number of times.</p></body></html>
+`
+
+// serveBotPlaceholder отвечает боту, не засчитывая переход: 410, если лимит уже исчерпан, иначе страница-заглушка
+func serveBotPlaceholder(w http.ResponseWriter, link *shortLink) {
+	used, err := store.Redemptions(ctx, link.id)
+	if err != nil {
+		log.Printf("[Redirect Service] Store error: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if used >= link.record.MaxClicks {
+		http.Error(w, "Short URL has reached its click limit", http.StatusGone)
+		return
+	}
+
+	log.Printf("[Redirect Service] Serving placeholder for bot on limited short code '%s'\n", link.id)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	w.Write([]byte(botPlaceholder))
 }
 
-func publishClickEvent(shortCode string, r *http.Request) {
-	event := ClickEvent{
-		ShortCode: shortCode,
+// withinActiveWindow проверяет окно активности ссылки (activeFrom/activeUntil). До начала окна
+// ссылка ведёт на запасной адрес, если он задан, а после конца отвечает 410; такие переходы не засчитываются.
+func withinActiveWindow(w http.ResponseWriter, r *http.Request, link *shortLink) bool {
+	now := time.Now()
+	if from := link.record.ActiveFrom; from != nil && now.Before(*from) {
+		if fallback := link.record.FallbackURL; fallback != "" {
+			log.Printf("[Redirect Service] Short code '%s' is not active until %s, redirecting to fallback %s\n",
+				link.id, from.Format(time.RFC3339), fallback)
+			w.Header().Set("Cache-Control", "no-store")
+			http.Redirect(w, r, fallback, http.StatusFound)
+			return false
+		}
+		log.Printf("[Redirect Service] Short code '%s' is not active until %s\n", link.id, from.Format(time.RFC3339))
+		http.Error(w, "Short URL is not active yet", http.StatusNotFound)
+		return false
+	}
+	if until := link.record.ActiveUntil; until != nil && !now.Before(*until) {
+		log.Printf("[Redirect Service] Short code '%s' stopped being active at %s\n", link.id, until.Format(time.RFC3339))
+		http.Error(w, "Short URL is no longer active", http.StatusGone)
+		return false
+	}
+	return true
+}
+
+// newClickEvent собирает событие перехода из запроса; данные читаются сразу, до асинхронной отправки
+func newClickEvent(link *shortLink, r *http.Request) ClickEvent {
+	device := detectDevice(r.UserAgent())
+	ip := clientIP(r)
+	return ClickEvent{
+		ShortCode: link.code,
+		Domain:    link.scope,
 		Timestamp: time.Now(),
 		UserAgent: r.UserAgent(),
-		IP:        getIP(r),
+		IP:        ip,
+		Platform:  device.Platform,
+		Device:    device.Device,
+		Country:   lookupCountry(ip),
+	}
+}
+
+// setCampaign записывает в событие метки кампании из адреса перехода: и заданные при создании ссылки,
+// и переданные параметрами запроса короткой ссылки
+func (e *ClickEvent) setCampaign(destination string) {
+	parsed, err := url.Parse(destination)
+	if err != nil {
+		return
 	}
+	utm := linkstore.ParseUTM(parsed.Query())
+	e.UTMSource, e.UTMMedium, e.UTMCampaign = utm.Source, utm.Medium, utm.Campaign
+	e.UTMTerm, e.UTMContent = utm.Term, utm.Content
+}
+
+func publishClickEvent(event ClickEvent) {
+	id := linkID(event.Domain, event.ShortCode)
 
 	jsonData, err := json.Marshal(event)
 	if err != nil {
@@ -194,28 +439,83 @@ func publishClickEvent(shortCode string, r *http.Request) {
 
 	// Отправка сообщения в Kafka
 	err = kafkaWriter.WriteMessages(context.Background(), kafka.Message{
-		Key:   []byte(shortCode),
+		Key:   []byte(id),
 		Value: jsonData,
 	})
 
 	if err != nil {
 		log.Printf("[Redirect Service] Failed to publish to Kafka: %v\n", err)
 	} else {
-		log.Printf("[Redirect Service] Published click event for '%s' to Kafka\n", shortCode)
+		log.Printf("[Redirect Service] Published click event for '%s' to Kafka\n", id)
+	}
+}
+
+// initTrustedProxies читает TRUSTED_PROXIES - адреса или подсети через запятую. Без него заголовки
+// X-Forwarded-For и X-Real-IP не учитываются в clientIP: их может подставить сам клиент.
+func initTrustedProxies() {
+	for _, entry := range strings.Split(getEnv("TRUSTED_PROXIES", ""), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Fatalf("Invalid TRUSTED_PROXIES entry %q: %v", entry, err)
+		}
+		trustedProxies = append(trustedProxies, network)
+	}
+}
+
+func isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
 	}
+	return false
 }
 
-func getIP(r *http.Request) string {
-	// Попытка получить реальный IP из заголовков
-	forwarded := r.Header.Get("X-Forwarded-For")
-	if forwarded != "" {
-		return forwarded
+// clientIP возвращает адрес клиента без порта. Это адрес соединения, если он не из TRUSTED_PROXIES;
+// иначе X-Forwarded-For читается справа налево до первого адреса не из доверенных прокси
+// (адреса левее мог подставить сам клиент), а без него берётся X-Real-IP.
+func clientIP(r *http.Request) string {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if !isTrustedProxy(ip) {
+		return ip
+	}
+
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			ip = hop
+			if !isTrustedProxy(hop) {
+				break
+			}
+		}
+		return ip
 	}
-	realIP := r.Header.Get("X-Real-IP")
-	if realIP != "" {
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
 		return realIP
 	}
-	return r.RemoteAddr
+	return ip
 }
 
 func respondJSON(w http.ResponseWriter, status int, data interface{}) {
@@ -230,3 +530,29 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("[Redirect Service] Invalid integer in %s=%q, using %d\n", key, value, defaultValue)
+		return defaultValue
+	}
+	return n
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("[Redirect Service] Invalid duration in %s=%q, using %s\n", key, value, defaultValue)
+		return defaultValue
+	}
+	return d
+}
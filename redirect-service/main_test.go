@@ -0,0 +1,214 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/itcaat/url-shortener-demo/pkg/linkstore"
+)
+
+// testService подменяет хранилище на память, а отправку событий - на канал, и возвращает его
+func testService(t *testing.T) chan ClickEvent {
+	t.Helper()
+	previousStore, previousPublish := store, publishClick
+	store = linkstore.NewMemory()
+	clicks := make(chan ClickEvent, 100)
+	publishClick = func(event ClickEvent) { clicks <- event }
+	t.Cleanup(func() {
+		store.Close()
+		store, publishClick = previousStore, previousPublish
+	})
+	return clicks
+}
+
+// putLink записывает ссылку с кодом code в хранилище
+func putLink(t *testing.T, code string, record *linkstore.Record) {
+	t.Helper()
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = time.Now().UTC()
+	}
+	if err := store.Put(ctx, code, record, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serve выполняет запрос к маршрутам сервиса
+func serve(method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	if method == http.MethodPost && body != "" {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	w := httptest.NewRecorder()
+	newHandler().ServeHTTP(w, r)
+	return w
+}
+
+// expectClick ждёт событие перехода от асинхронной отправки
+func expectClick(t *testing.T, clicks chan ClickEvent) ClickEvent {
+	t.Helper()
+	select {
+	case event := <-clicks:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no click event published")
+		return ClickEvent{}
+	}
+}
+
+// expectNoClick проверяет, что переход не попал в аналитику
+func expectNoClick(t *testing.T, clicks chan ClickEvent) {
+	t.Helper()
+	select {
+	case event := <-clicks:
+		t.Fatalf("unexpected click event %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestRedirectNotFound(t *testing.T) {
+	clicks := testService(t)
+	if w := serve("GET", "/missing", ""); w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", w.Code)
+	}
+	expectNoClick(t, clicks)
+}
+
+func TestRedirectPublishesClick(t *testing.T) {
+	clicks := testService(t)
+	putLink(t, "abc", &linkstore.Record{URL: "https://example.com/landing?utm_source=news"})
+
+	w := serve("GET", "/abc", "", "User-Agent", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)")
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://example.com/landing?utm_source=news" {
+		t.Fatalf("response = %d %q, want 302 to the destination", w.Code, w.Header().Get("Location"))
+	}
+	event := expectClick(t, clicks)
+	if event.ShortCode != "abc" || event.Platform != linkstore.PlatformIOS || event.UTMSource != "news" {
+		t.Errorf("click event = %+v", event)
+	}
+}
+
+func TestClickEventUsesClientIP(t *testing.T) {
+	previous := trustedProxies
+	t.Cleanup(func() { trustedProxies = previous })
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+
+	tests := []struct {
+		name    string
+		trusted []*net.IPNet
+		remote  string
+		want    string
+	}{
+		{"spoofed header is ignored", nil, "203.0.113.7:51234", "203.0.113.7"},
+		{"header from a trusted proxy", []*net.IPNet{proxies}, "10.0.0.2:51234", "198.51.100.4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clicks := testService(t)
+			trustedProxies = tt.trusted
+			putLink(t, "abc", &linkstore.Record{URL: "https://example.com"})
+
+			r := httptest.NewRequest("GET", "/abc", nil)
+			r.RemoteAddr = tt.remote
+			r.Header.Set("X-Forwarded-For", "192.0.2.1, 198.51.100.4")
+			newHandler().ServeHTTP(httptest.NewRecorder(), r)
+
+			if event := expectClick(t, clicks); event.IP != tt.want {
+				t.Errorf("click IP = %q, want %q", event.IP, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedirectClickLimitIgnoresBots(t *testing.T) {
+	clicks := testService(t)
+	putLink(t, "once", &linkstore.Record{URL: "https://example.com/secret", MaxClicks: 1})
+
+	const slackbot = "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"
+	const browser = "Mozilla/5.0 (Windows NT 10.0; Win64; x64)"
+
+	// Превью в мессенджере не тратит переход и не видит адрес
+	for i := 0; i < 3; i++ {
+		w := serve("GET", "/once", "", "User-Agent", slackbot)
+		if w.Code != http.StatusOK || w.Header().Get("Location") != "" {
+			t.Fatalf("bot response = %d %q, want 200 without a redirect", w.Code, w.Header().Get("Location"))
+		}
+		if strings.Contains(w.Body.String(), "example.com") {
+			t.Fatal("bot placeholder reveals the destination")
+		}
+	}
+	expectNoClick(t, clicks)
+
+	w := serve("GET", "/once", "", "User-Agent", browser)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://example.com/secret" {
+		t.Fatalf("visitor response = %d %q, want 302 to the destination", w.Code, w.Header().Get("Location"))
+	}
+	if event := expectClick(t, clicks); !event.Exhausted {
+		t.Error("last allowed click is not marked exhausted")
+	}
+
+	for _, ua := range []string{browser, slackbot} {
+		if w := serve("GET", "/once", "", "User-Agent", ua); w.Code != http.StatusGone {
+			t.Errorf("%q after the limit: status = %d, want 410", ua, w.Code)
+		}
+	}
+	expectNoClick(t, clicks)
+}
+
+func TestRedirectExpired(t *testing.T) {
+	clicks := testService(t)
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	putLink(t, "old", &linkstore.Record{URL: "https://example.com/old", ExpiresAt: &past})
+	putLink(t, "new", &linkstore.Record{URL: "https://example.com/new", ExpiresAt: &future})
+
+	// Истёкшая ссылка хранится до конца периода хранения, но уже не перенаправляет
+	if w := serve("GET", "/old", ""); w.Code != http.StatusGone || w.Header().Get("Location") != "" {
+		t.Errorf("expired: %d %q, want 410", w.Code, w.Header().Get("Location"))
+	}
+	expectNoClick(t, clicks)
+
+	if w := serve("GET", "/new", ""); w.Code != http.StatusFound || w.Header().Get("Location") != "https://example.com/new" {
+		t.Errorf("not expired: %d %q, want 302 to the destination", w.Code, w.Header().Get("Location"))
+	}
+	expectClick(t, clicks)
+}
+
+func TestRedirectActivationWindow(t *testing.T) {
+	clicks := testService(t)
+	now := time.Now()
+	later, earlier := now.Add(time.Hour), now.Add(-time.Hour)
+	putLink(t, "soon", &linkstore.Record{URL: "https://example.com/launch", ActiveFrom: &later, FallbackURL: "https://example.com/teaser"})
+	putLink(t, "hidden", &linkstore.Record{URL: "https://example.com/launch", ActiveFrom: &later})
+	putLink(t, "ended", &linkstore.Record{URL: "https://example.com/launch", ActiveUntil: &earlier})
+	putLink(t, "live", &linkstore.Record{URL: "https://example.com/launch", ActiveFrom: &earlier, ActiveUntil: &later})
+
+	tests := []struct {
+		code     string
+		status   int
+		location string
+	}{
+		{"soon", http.StatusFound, "https://example.com/teaser"},
+		{"hidden", http.StatusNotFound, ""},
+		{"ended", http.StatusGone, ""},
+	}
+	for _, tt := range tests {
+		w := serve("GET", "/"+tt.code, "")
+		if w.Code != tt.status || w.Header().Get("Location") != tt.location {
+			t.Errorf("%s: %d %q, want %d %q", tt.code, w.Code, w.Header().Get("Location"), tt.status, tt.location)
+		}
+	}
+	// Переходы вне окна не засчитываются
+	expectNoClick(t, clicks)
+
+	w := serve("GET", "/live", "")
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://example.com/launch" {
+		t.Errorf("live: %d %q, want 302 to the destination", w.Code, w.Header().Get("Location"))
+	}
+	expectClick(t, clicks)
+}
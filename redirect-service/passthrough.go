@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/itcaat/url-shortener-demo/pkg/linkstore"
+)
+
+// trailingPath возвращает путь после кода в исходном (экранированном) виде: /abc/docs/a%2Fb -> docs/a%2Fb.
+// Второй результат - запрос пришёл на маршрут с путём после кода.
+func trailingPath(r *http.Request) (string, bool) {
+	if _, ok := mux.Vars(r)["path"]; !ok {
+		return "", false
+	}
+	escaped := strings.TrimPrefix(r.URL.EscapedPath(), "/")
+	_, rest, _ := strings.Cut(escaped, "/")
+	return rest, true
+}
+
+// validTrailingPath не пропускает сегменты "." и "..": они позволили бы выйти за пределы пути адреса
+func validTrailingPath(rest string) bool {
+	for _, segment := range strings.Split(rest, "/") {
+		decoded, err := url.PathUnescape(segment)
+		if err != nil || decoded == "." || decoded == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// passthroughDestination дополняет адрес перехода путём после кода и параметрами запроса
+// согласно настройкам ссылки. Параметры переносятся в исходном виде и порядке.
+func passthroughDestination(destination string, link *shortLink, r *http.Request) string {
+	record := link.record
+	forwardQuery := record.QueryPassthrough != "" && r.URL.RawQuery != ""
+	forwardPath := record.PathPassthrough && link.path != ""
+	if !forwardQuery && !forwardPath {
+		return destination
+	}
+
+	u, err := url.Parse(destination)
+	if err != nil {
+		return destination
+	}
+
+	if forwardPath {
+		escaped := strings.TrimSuffix(u.EscapedPath(), "/") + "/" + link.path
+		if path, err := url.PathUnescape(escaped); err == nil {
+			u.Path, u.RawPath = path, escaped
+		}
+	}
+
+	if forwardQuery {
+		u.RawQuery = mergeRawQuery(u.RawQuery, r.URL.RawQuery, record.QueryPassthrough)
+	}
+	return u.String()
+}
+
+// mergeRawQuery объединяет строки параметров. При QueryMerge параметры запроса добавляются,
+// только если такого имени нет в адресе; при QueryOverride одноимённые параметры адреса убираются.
+func mergeRawQuery(destination, incoming, policy string) string {
+	destinationPairs := splitRawQuery(destination)
+	incomingPairs := splitRawQuery(incoming)
+
+	present := func(pairs []string) map[string]bool {
+		names := make(map[string]bool, len(pairs))
+		for _, pair := range pairs {
+			names[queryName(pair)] = true
+		}
+		return names
+	}
+
+	var merged []string
+	switch policy {
+	case linkstore.QueryOverride:
+		overridden := present(incomingPairs)
+		for _, pair := range destinationPairs {
+			if !overridden[queryName(pair)] {
+				merged = append(merged, pair)
+			}
+		}
+		merged = append(merged, incomingPairs...)
+	default:
+		existing := present(destinationPairs)
+		merged = destinationPairs
+		for _, pair := range incomingPairs {
+			if !existing[queryName(pair)] {
+				merged = append(merged, pair)
+			}
+		}
+	}
+	return strings.Join(merged, "&")
+}
+
+func splitRawQuery(raw string) []string {
+	var pairs []string
+	for _, pair := range strings.Split(raw, "&") {
+		if pair != "" {
+			pairs = append(pairs, pair)
+		}
+	}
+	return pairs
+}
+
+// queryName возвращает раскодированное имя параметра из пары "name=value"
+func queryName(pair string) string {
+	name, _, _ := strings.Cut(pair, "=")
+	if decoded, err := url.QueryUnescape(name); err == nil {
+		return decoded
+	}
+	return name
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/itcaat/url-shortener-demo/pkg/linkstore"
+)
+
+func TestMergeRawQuery(t *testing.T) {
+	tests := []struct {
+		name        string
+		destination string
+		incoming    string
+		policy      string
+		want        string
+	}{
+		{"empty incoming", "a=1", "", linkstore.QueryMerge, "a=1"},
+		{"empty destination", "", "a=1", linkstore.QueryMerge, "a=1"},
+		{"merge adds new names", "a=1", "b=2", linkstore.QueryMerge, "a=1&b=2"},
+		{"merge keeps destination values", "a=1&b=2", "b=3&c=4", linkstore.QueryMerge, "a=1&b=2&c=4"},
+		{"merge is the default policy", "a=1", "a=2&b=3", "", "a=1&b=3"},
+		{"override replaces destination values", "a=1&b=2", "b=3&c=4", linkstore.QueryOverride, "a=1&b=3&c=4"},
+		{"override drops every repeated value", "tag=x&tag=y&a=1", "tag=z", linkstore.QueryOverride, "a=1&tag=z"},
+		{"repeated incoming names are kept", "", "tag=x&tag=y", linkstore.QueryMerge, "tag=x&tag=y"},
+		{"names compare decoded", "utm%5Fsource=site", "utm_source=ads", linkstore.QueryMerge, "utm%5Fsource=site"},
+		{"encoding is preserved", "q=a%20b", "next=%2Fhome", linkstore.QueryMerge, "q=a%20b&next=%2Fhome"},
+		{"names without values", "flag", "flag&debug", linkstore.QueryMerge, "flag&debug"},
+		{"empty pairs are skipped", "a=1&&", "&b=2", linkstore.QueryMerge, "a=1&b=2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeRawQuery(tt.destination, tt.incoming, tt.policy); got != tt.want {
+				t.Errorf("mergeRawQuery(%q, %q, %q) = %q, want %q", tt.destination, tt.incoming, tt.policy, got, tt.want)
+			}
+		})
+	}
+}
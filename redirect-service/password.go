@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/itcaat/url-shortener-demo/pkg/linkstore"
+)
+
+const (
+	accessCookiePrefix = "link_access_"
+	maxPasswordForm    = 4 << 10
+)
+
+var (
+	// Сколько действует доступ к защищённой ссылке после ввода пароля
+	accessCookieTTL = getEnvDuration("PASSWORD_COOKIE_TTL", 10*time.Minute)
+
+	// Ключ подписи cookie доступа
+	accessCookieSecret []byte
+
+	// Попытки ввода пароля по ссылке и IP
+	passwordAttempts = newAttemptLimiter(
+		getEnvInt("PASSWORD_MAX_ATTEMPTS", 5),
+		getEnvDuration("PASSWORD_ATTEMPT_WINDOW", 15*time.Minute),
+	)
+)
+
+var passwordFormTemplate = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Password required</title>
+<style>
+body { font-family: system-ui, sans-serif; display: flex; justify-content: center; margin-top: 15vh; }
+form { display: flex; flex-direction: column; gap: .75rem; width: 18rem; }
+.error { color: #b00020; }
+</style>
+</head>
+<body>
+<form method="post">
+<h1>Password required</h1>
+<p>This link is protected. Enter the password to continue.</p>
+{{if .}}<p class="error">{{.}}</p>{{end}}
+<input type="password" name="password" autocomplete="current-password" required autofocus>
+<button type="submit">Continue</button>
+</form>
+</body>
+</html>
+`))
+
+// initPasswords читает PASSWORD_COOKIE_SECRET. Без него ключ генерируется при запуске:
+// cookie перестают действовать после перезапуска и не принимаются другими репликами.
+func initPasswords() {
+	if secret := getEnv("PASSWORD_COOKIE_SECRET", ""); secret != "" {
+		accessCookieSecret = []byte(secret)
+		return
+	}
+	accessCookieSecret = make([]byte, 32)
+	if _, err := rand.Read(accessCookieSecret); err != nil {
+		log.Fatalf("Failed to generate cookie secret: %v", err)
+	}
+	log.Println("[Redirect Service] ⚠️  PASSWORD_COOKIE_SECRET is not set, access cookies are valid only for this instance")
+}
+
+// unlockHandler проверяет пароль из формы и выдаёт cookie доступа к ссылке. POST на открытую ссылку
+// с кодом 307 или 308 - это запрос API-клиента, который перенаправляется с сохранением метода.
+func unlockHandler(w http.ResponseWriter, r *http.Request) {
+	link, ok := loadShortLink(w, r)
+	if !ok {
+		return
+	}
+	if link.record.PasswordHash == "" {
+		if preservesMethod(redirectStatus(link.record)) {
+			serveRedirect(w, r, link)
+			return
+		}
+		seeShortLink(w, r)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxPasswordForm)
+	if err := r.ParseForm(); err != nil {
+		renderPasswordForm(w, http.StatusBadRequest, "Invalid form submission.")
+		return
+	}
+
+	// Попытка засчитывается до проверки пароля: иначе параллельные запросы проходят лимит,
+	// пока идёт медленная проверка. Верный пароль сбрасывает счётчик.
+	key := link.id + "|" + clientIP(r)
+	if retryAfter, ok := passwordAttempts.reserve(key); !ok {
+		log.Printf("[Redirect Service] Too many password attempts for '%s'\n", link.id)
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		renderPasswordForm(w, http.StatusTooManyRequests, "Too many attempts. Try again later.")
+		return
+	}
+	if !linkstore.VerifyPassword(link.record.PasswordHash, r.PostForm.Get("password")) {
+		log.Printf("[Redirect Service] Wrong password for '%s'\n", link.id)
+		renderPasswordForm(w, http.StatusUnauthorized, "Incorrect password.")
+		return
+	}
+	passwordAttempts.reset(key)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     accessCookieName(link.id),
+		Value:    signAccess(link, time.Now().Add(accessCookieTTL)),
+		MaxAge:   int(accessCookieTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	log.Printf("[Redirect Service] Password accepted for '%s'\n", link.id)
+	seeShortLink(w, r)
+}
+
+// seeShortLink отправляет браузер обратно на тот же адрес (с путём после кода и параметрами) GET-запросом.
+// Адрес относительный, чтобы сохранить префикс пути, который отрезает ingress.
+func seeShortLink(w http.ResponseWriter, r *http.Request) {
+	escaped := r.URL.EscapedPath()
+	location := "./" + escaped[strings.LastIndex(escaped, "/")+1:]
+	if r.URL.RawQuery != "" {
+		location += "?" + r.URL.RawQuery
+	}
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusSeeOther)
+}
+
+func renderPasswordForm(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.WriteHeader(status)
+	if err := passwordFormTemplate.Execute(w, message); err != nil {
+		log.Printf("[Redirect Service] Failed to render password form: %v\n", err)
+	}
+}
+
+// accessCookieName - у каждой ссылки своя cookie, чтобы доступ к одной не вытеснял другую
+func accessCookieName(id string) string {
+	return linkCookieName(accessCookiePrefix, id)
+}
+
+// linkCookieName строит имя cookie ссылки из префикса и хеша её идентификатора:
+// сам идентификатор может содержать символы, недопустимые в имени cookie
+func linkCookieName(prefix, id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return prefix + hex.EncodeToString(sum[:8])
+}
+
+// signAccess подписывает "<срок>.<подпись>". В подпись входит хеш пароля,
+// поэтому после смены пароля выданные cookie перестают действовать.
+func signAccess(link *shortLink, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return exp + "." + accessSignature(link, exp)
+}
+
+func accessSignature(link *shortLink, exp string) string {
+	mac := hmac.New(sha256.New, accessCookieSecret)
+	mac.Write([]byte(link.id + "|" + exp + "|" + link.record.PasswordHash))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// hasLinkAccess проверяет подпись и срок cookie доступа к ссылке
+func hasLinkAccess(r *http.Request, link *shortLink) bool {
+	cookie, err := r.Cookie(accessCookieName(link.id))
+	if err != nil {
+		return false
+	}
+	exp, signature, ok := strings.Cut(cookie.Value, ".")
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || !time.Now().Before(time.Unix(unix, 0)) {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(accessSignature(link, exp)))
+}
+
+// attemptLimiter считает попытки в фиксированном окне. Счётчики живут в памяти
+// процесса, поэтому при N репликах за окно возможно до N*max попыток, а после перезапуска счёт начинается заново.
+type attemptLimiter struct {
+	mu       sync.Mutex
+	max      int
+	window   time.Duration
+	attempts map[string]*attemptWindow
+}
+
+type attemptWindow struct {
+	used  int
+	start time.Time
+}
+
+func newAttemptLimiter(max int, window time.Duration) *attemptLimiter {
+	return &attemptLimiter{max: max, window: window, attempts: map[string]*attemptWindow{}}
+}
+
+// reserve засчитывает попытку, если лимит ещё не исчерпан; иначе возвращает, через сколько он сбросится
+func (l *attemptLimiter) reserve(key string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	attempt, ok := l.attempts[key]
+	if !ok || now.Sub(attempt.start) >= l.window {
+		attempt = &attemptWindow{start: now}
+		l.attempts[key] = attempt
+	}
+	if attempt.used >= l.max {
+		return l.window - now.Sub(attempt.start), false
+	}
+	attempt.used++
+
+	// Чистим устаревшие окна, чтобы перебор по разным IP не раздувал память
+	if len(l.attempts) > 10000 {
+		for k, a := range l.attempts {
+			if now.Sub(a.start) >= l.window {
+				delete(l.attempts, k)
+			}
+		}
+	}
+	return 0, true
+}
+
+func (l *attemptLimiter) reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.attempts, key)
+}
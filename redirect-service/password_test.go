@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/itcaat/url-shortener-demo/pkg/linkstore"
+)
+
+// Хеш пароля "correct horse" с малым числом итераций, чтобы тесты не тратили время на PBKDF2
+const testPasswordHash = "pbkdf2-sha256$1000$MDEyMzQ1Njc4OWFiY2RlZg$cBg8D2DungRB9k76szThf5ehfyBz991ay6PT8Srwk4M"
+
+// usePasswordLimit подменяет лимит попыток ввода пароля на время теста
+func usePasswordLimit(t *testing.T, max int) {
+	previous, previousSecret := passwordAttempts, accessCookieSecret
+	passwordAttempts = newAttemptLimiter(max, time.Minute)
+	accessCookieSecret = []byte("test secret")
+	t.Cleanup(func() { passwordAttempts, accessCookieSecret = previous, previousSecret })
+}
+
+func TestAttemptLimiterReserve(t *testing.T) {
+	limiter := newAttemptLimiter(3, time.Minute)
+	for i := 1; i <= 3; i++ {
+		if _, ok := limiter.reserve("link|1.2.3.4"); !ok {
+			t.Fatalf("attempt %d rejected", i)
+		}
+	}
+	retryAfter, ok := limiter.reserve("link|1.2.3.4")
+	if ok {
+		t.Fatal("attempt over the limit accepted")
+	}
+	if retryAfter <= 0 || retryAfter > time.Minute {
+		t.Errorf("retryAfter = %v, want within the window", retryAfter)
+	}
+	if _, ok := limiter.reserve("link|5.6.7.8"); !ok {
+		t.Error("limit is shared between clients")
+	}
+
+	limiter.reset("link|1.2.3.4")
+	if _, ok := limiter.reserve("link|1.2.3.4"); !ok {
+		t.Error("attempt rejected after reset")
+	}
+}
+
+func TestAttemptLimiterWindowExpires(t *testing.T) {
+	limiter := newAttemptLimiter(1, 20*time.Millisecond)
+	limiter.reserve("key")
+	if _, ok := limiter.reserve("key"); ok {
+		t.Fatal("second attempt accepted inside the window")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := limiter.reserve("key"); !ok {
+		t.Error("attempt rejected after the window expired")
+	}
+}
+
+// Параллельные попытки не должны проходить лимит, пока идёт проверка пароля
+func TestAttemptLimiterConcurrent(t *testing.T) {
+	limiter := newAttemptLimiter(5, time.Minute)
+	var accepted atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok := limiter.reserve("key"); ok {
+				accepted.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if accepted.Load() != 5 {
+		t.Errorf("%d parallel attempts accepted, want 5", accepted.Load())
+	}
+}
+
+func TestPasswordFlow(t *testing.T) {
+	clicks := testService(t)
+	usePasswordLimit(t, 5)
+	putLink(t, "locked", &linkstore.Record{URL: "https://example.com/private", PasswordHash: testPasswordHash})
+
+	// Без cookie показывается форма, а переход не засчитывается
+	w := serve("GET", "/locked?ref=mail", "")
+	if w.Code != http.StatusOK || w.Header().Get("Location") != "" || w.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("form = %d %q %q, want 200 no-store without a redirect", w.Code, w.Header().Get("Location"), w.Header().Get("Cache-Control"))
+	}
+	expectNoClick(t, clicks)
+
+	if w := serve("POST", "/locked?ref=mail", "password=battery+staple"); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong password: status = %d, want 401", w.Code)
+	}
+
+	w = serve("POST", "/locked?ref=mail", "password=correct+horse")
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "./locked?ref=mail" {
+		t.Fatalf("unlock = %d %q, want 303 back to the short link", w.Code, w.Header().Get("Location"))
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != accessCookieName("locked") || !cookies[0].HttpOnly {
+		t.Fatalf("cookies = %+v, want one HttpOnly access cookie", cookies)
+	}
+
+	w = serve("GET", "/locked?ref=mail", "", "Cookie", cookies[0].String())
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://example.com/private" {
+		t.Fatalf("unlocked = %d %q, want 302 to the destination", w.Code, w.Header().Get("Location"))
+	}
+	expectClick(t, clicks)
+
+	// Cookie одной ссылки не открывает другую
+	putLink(t, "other", &linkstore.Record{URL: "https://example.com/other", PasswordHash: testPasswordHash})
+	if w := serve("GET", "/other", "", "Cookie", accessCookieName("other")+"="+cookies[0].Value); w.Header().Get("Location") != "" {
+		t.Error("access cookie of one link opened another")
+	}
+}
+
+func TestPasswordAttemptLimit(t *testing.T) {
+	testService(t)
+	usePasswordLimit(t, 3)
+	putLink(t, "locked", &linkstore.Record{URL: "https://example.com/private", PasswordHash: testPasswordHash})
+
+	for i := 1; i <= 3; i++ {
+		if w := serve("POST", "/locked", "password=wrong"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status = %d, want 401", i, w.Code)
+		}
+	}
+	// Лимит исчерпан: даже верный пароль не проверяется
+	w := serve("POST", "/locked", "password=correct+horse")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", w.Code)
+	}
+	if retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || retryAfter <= 0 || retryAfter > 61 {
+		t.Errorf("Retry-After = %q, want seconds until the window ends", w.Header().Get("Retry-After"))
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Error("access cookie issued over the limit")
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/itcaat/url-shortener-demo/pkg/linkstore"
+)
+
+var (
+	// Код перенаправления для ссылок, у которых он не задан
+	defaultRedirectStatus = initRedirectStatus()
+
+	// Сколько браузеры и прокси могут хранить постоянное перенаправление (301, 308)
+	permanentRedirectMaxAge = getEnvDuration("PERMANENT_REDIRECT_MAX_AGE", 24*time.Hour)
+)
+
+// initRedirectStatus читает DEFAULT_REDIRECT_STATUS; неподдерживаемый код заменяется на 302
+func initRedirectStatus() int {
+	status := getEnvInt("DEFAULT_REDIRECT_STATUS", http.StatusFound)
+	if !slices.Contains(linkstore.RedirectStatuses, status) {
+		log.Printf("[Redirect Service] Unsupported DEFAULT_REDIRECT_STATUS=%d, using %d\n", status, http.StatusFound)
+		return http.StatusFound
+	}
+	return status
+}
+
+// redirectStatus возвращает код перенаправления ссылки
+func redirectStatus(record *linkstore.Record) int {
+	if slices.Contains(linkstore.RedirectStatuses, record.RedirectStatus) {
+		return record.RedirectStatus
+	}
+	return defaultRedirectStatus
+}
+
+// Методы кроме GET и POST, которые перенаправляются только ссылками с 307 и 308
+var preservedMethods = []string{"PUT", "PATCH", "DELETE"}
+
+// preservesMethod сообщает, что клиент повторит запрос на новый адрес тем же методом и с тем же телом
+func preservesMethod(status int) bool {
+	return status == http.StatusTemporaryRedirect || status == http.StatusPermanentRedirect
+}
+
+// methodRedirectHandler перенаправляет PUT, PATCH и DELETE API-клиентов по ссылкам с 307 и 308;
+// остальные ссылки принимают только GET и POST
+func methodRedirectHandler(w http.ResponseWriter, r *http.Request) {
+	link, ok := loadShortLink(w, r)
+	if !ok {
+		return
+	}
+	if !preservesMethod(redirectStatus(link.record)) {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	serveRedirect(w, r, link)
+}
+
+// redirectCacheControl выбирает Cache-Control перенаправления. Временные перенаправления не кешируются,
+// чтобы каждый переход доходил до сервиса и попадал в аналитику. Постоянные кешируются на
+// PERMANENT_REDIRECT_MAX_AGE, но не дольше срока действия и окна активности ссылки; если адрес зависит
+// от посетителя (правила, A/B-тест), - только в браузере. Ссылки с паролем, лимитом переходов
+// и расписанием правил не кешируются: повторный переход из кеша обошёл бы проверки.
+func redirectCacheControl(record *linkstore.Record, status int, now time.Time) string {
+	if status != http.StatusMovedPermanently && status != http.StatusPermanentRedirect ||
+		record.PasswordHash != "" || record.MaxClicks > 0 || hasSchedule(record) {
+		return "no-store"
+	}
+
+	maxAge := permanentRedirectMaxAge
+	for _, end := range []*time.Time{record.ExpiresAt, record.ActiveUntil} {
+		if end != nil {
+			maxAge = min(maxAge, end.Sub(now))
+		}
+	}
+	if maxAge < time.Second {
+		return "no-store"
+	}
+
+	scope := "public"
+	if len(record.Rules) > 0 || len(record.Variants) > 0 {
+		scope = "private"
+	}
+	return fmt.Sprintf("%s, max-age=%d", scope, int(maxAge.Seconds()))
+}
+
+// hasSchedule сообщает, что выбор адреса зависит от времени перехода
+func hasSchedule(record *linkstore.Record) bool {
+	for _, rule := range record.Rules {
+		if len(rule.Schedule) > 0 {
+			return true
+		}
+	}
+	return false
+}
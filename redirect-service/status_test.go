@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/itcaat/url-shortener-demo/pkg/linkstore"
+)
+
+func TestRedirectStatus(t *testing.T) {
+	tests := []struct {
+		status       int
+		want         int
+		cacheControl string
+	}{
+		{0, http.StatusFound, "no-store"},
+		{http.StatusMovedPermanently, http.StatusMovedPermanently, "public, max-age=86400"},
+		{http.StatusFound, http.StatusFound, "no-store"},
+		{http.StatusTemporaryRedirect, http.StatusTemporaryRedirect, "no-store"},
+		{http.StatusPermanentRedirect, http.StatusPermanentRedirect, "public, max-age=86400"},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.want), func(t *testing.T) {
+			testService(t)
+			putLink(t, "abc", &linkstore.Record{URL: "https://example.com", RedirectStatus: tt.status})
+
+			w := serve("GET", "/abc", "")
+			if w.Code != tt.want || w.Header().Get("Location") != "https://example.com" {
+				t.Errorf("response = %d %q, want %d", w.Code, w.Header().Get("Location"), tt.want)
+			}
+			if got := w.Header().Get("Cache-Control"); got != tt.cacheControl {
+				t.Errorf("Cache-Control = %q, want %q", got, tt.cacheControl)
+			}
+		})
+	}
+}
+
+func TestRedirectCacheControlLimits(t *testing.T) {
+	soon := time.Now().Add(time.Hour)
+	tests := []struct {
+		name   string
+		record linkstore.Record
+		want   string
+	}{
+		{"expiry caps max-age", linkstore.Record{ExpiresAt: &soon}, "public, max-age=3599"},
+		{"rules keep it in the browser", linkstore.Record{Rules: []linkstore.Rule{{Name: "ios", Platforms: []string{"ios"}, URL: "https://example.com/ios"}}}, "private, max-age=86400"},
+		{"password is never cached", linkstore.Record{PasswordHash: "pbkdf2-sha256$1$c2FsdA$a2V5"}, "no-store"},
+		{"click limit is never cached", linkstore.Record{MaxClicks: 3}, "no-store"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := tt.record
+			record.URL = "https://example.com"
+			if got := redirectCacheControl(&record, http.StatusMovedPermanently, time.Now()); got != tt.want {
+				t.Errorf("redirectCacheControl = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedirectPreservedMethods(t *testing.T) {
+	tests := []struct {
+		method string
+		status int
+		want   int
+	}{
+		{"PUT", http.StatusTemporaryRedirect, http.StatusTemporaryRedirect},
+		{"PATCH", http.StatusPermanentRedirect, http.StatusPermanentRedirect},
+		{"DELETE", http.StatusTemporaryRedirect, http.StatusTemporaryRedirect},
+		{"POST", http.StatusTemporaryRedirect, http.StatusTemporaryRedirect},
+		{"PUT", http.StatusFound, http.StatusMethodNotAllowed},
+		{"DELETE", http.StatusMovedPermanently, http.StatusMethodNotAllowed},
+		// POST на ссылку без сохранения метода возвращает браузер на ту же ссылку GET-запросом
+		{"POST", http.StatusFound, http.StatusSeeOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+http.StatusText(tt.status), func(t *testing.T) {
+			clicks := testService(t)
+			putLink(t, "hooks", &linkstore.Record{URL: "https://api.example.com/v2/hooks", RedirectStatus: tt.status})
+
+			w := serve(tt.method, "/hooks", `{"event":"ping"}`)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+			if preservesMethod(tt.want) {
+				if w.Header().Get("Location") != "https://api.example.com/v2/hooks" {
+					t.Errorf("Location = %q", w.Header().Get("Location"))
+				}
+				expectClick(t, clicks)
+			} else {
+				expectNoClick(t, clicks)
+			}
+		})
+	}
+}
+
+func TestRedirectCORSAllowsPreservedMethods(t *testing.T) {
+	testService(t)
+	w := serve("OPTIONS", "/hooks", "",
+		"Origin", "https://app.example.com",
+		"Access-Control-Request-Method", "PUT")
+	if got := w.Header().Get("Access-Control-Allow-Methods"); got != "PUT" {
+		t.Errorf("Access-Control-Allow-Methods = %q, want PUT", got)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"net/http"
+	"time"
+
+	"github.com/itcaat/url-shortener-demo/pkg/linkstore"
+)
+
+const variantCookiePrefix = "link_variant_"
+
+// Сколько посетитель остаётся в выбранном варианте A/B-теста
+var variantCookieTTL = getEnvDuration("VARIANT_COOKIE_TTL", 30*24*time.Hour)
+
+// pickVariant выбирает вариант A/B-теста для посетителя. Вариант из cookie сохраняется, пока он есть
+// в ссылке; новому посетителю вариант выбирается по хешу ссылки и IP, поэтому повторные переходы
+// без cookie (например, из другого браузера за тем же адресом) попадают туда же.
+func pickVariant(w http.ResponseWriter, r *http.Request, link *shortLink) (linkstore.Variant, bool) {
+	variants := link.record.Variants
+	if len(variants) == 0 {
+		return linkstore.Variant{}, false
+	}
+
+	cookieName := linkCookieName(variantCookiePrefix, link.id)
+	if cookie, err := r.Cookie(cookieName); err == nil {
+		for _, variant := range variants {
+			if variant.Name == cookie.Value && variant.Weight > 0 {
+				return variant, true
+			}
+		}
+	}
+
+	variant := weightedVariant(variants, link.id+"|"+clientIP(r))
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieName,
+		Value:    variant.Name,
+		MaxAge:   int(variantCookieTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	return variant, true
+}
+
+// weightedVariant детерминированно выбирает вариант по ключу пропорционально весам
+func weightedVariant(variants []linkstore.Variant, key string) linkstore.Variant {
+	var total uint64
+	for _, variant := range variants {
+		total += uint64(max(variant.Weight, 0))
+	}
+	if total == 0 {
+		return variants[0]
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	point := binary.BigEndian.Uint64(sum[:8]) % total
+	for _, variant := range variants {
+		weight := uint64(max(variant.Weight, 0))
+		if point < weight {
+			return variant
+		}
+		point -= weight
+	}
+	return variants[len(variants)-1]
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/itcaat/url-shortener-demo/pkg/linkstore"
+)
+
+func TestWeightedVariantIsDeterministic(t *testing.T) {
+	variants := []linkstore.Variant{{Name: "a", Weight: 1}, {Name: "b", Weight: 1}}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("visitor-%d", i)
+		first := weightedVariant(variants, key)
+		for j := 0; j < 3; j++ {
+			if again := weightedVariant(variants, key); again.Name != first.Name {
+				t.Fatalf("weightedVariant(%q) = %q, then %q", key, first.Name, again.Name)
+			}
+		}
+	}
+}
+
+func TestWeightedVariantDistribution(t *testing.T) {
+	tests := []struct {
+		name     string
+		variants []linkstore.Variant
+		want     map[string]float64
+	}{
+		{
+			name:     "equal weights",
+			variants: []linkstore.Variant{{Name: "a", Weight: 50}, {Name: "b", Weight: 50}},
+			want:     map[string]float64{"a": 0.5, "b": 0.5},
+		},
+		{
+			name:     "uneven weights",
+			variants: []linkstore.Variant{{Name: "a", Weight: 1}, {Name: "b", Weight: 3}},
+			want:     map[string]float64{"a": 0.25, "b": 0.75},
+		},
+		{
+			name:     "zero and negative weights are never chosen",
+			variants: []linkstore.Variant{{Name: "a", Weight: 0}, {Name: "b", Weight: 2}, {Name: "c", Weight: -5}},
+			want:     map[string]float64{"b": 1},
+		},
+		{
+			name:     "all weights zero fall back to the first variant",
+			variants: []linkstore.Variant{{Name: "a", Weight: 0}, {Name: "b", Weight: 0}},
+			want:     map[string]float64{"a": 1},
+		},
+	}
+
+	const keys = 10000
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counts := map[string]int{}
+			for i := 0; i < keys; i++ {
+				counts[weightedVariant(tt.variants, fmt.Sprintf("visitor-%d", i)).Name]++
+			}
+			for name, count := range counts {
+				if _, ok := tt.want[name]; !ok {
+					t.Errorf("variant %q chosen %d times, want never", name, count)
+				}
+			}
+			for name, share := range tt.want {
+				if got := float64(counts[name]) / keys; math.Abs(got-share) > 0.03 {
+					t.Errorf("variant %q share = %.3f, want %.2f", name, got, share)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	aliasMinLength = 3
+	aliasMaxLength = 32
+)
+
+// Алиас: латиница, цифры, '-' и '_', начинается с буквы или цифры
+var aliasPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+
+// Зарезервированные слова, которые пересекаются с маршрутами сервисов
+var reservedAliases = map[string]bool{
+	"health":      true,
+	"api":         true,
+	"stats":       true,
+	"shorten":     true,
+	"import":      true,
+	"export":      true,
+	"links":       true,
+	"admin":       true,
+	"static":      true,
+	"assets":      true,
+	"favicon.ico": true,
+	"robots.txt":  true,
+}
+
+// validateAlias проверяет пользовательский алиас на допустимые символы, длину и зарезервированные слова
+func validateAlias(alias string) error {
+	if len(alias) < aliasMinLength || len(alias) > aliasMaxLength {
+		return fmt.Errorf("alias must be between %d and %d characters long", aliasMinLength, aliasMaxLength)
+	}
+	if !aliasPattern.MatchString(alias) {
+		return fmt.Errorf("alias may contain only letters, digits, '-' and '_' and must start with a letter or digit")
+	}
+	if reservedAliases[strings.ToLower(alias)] {
+		return fmt.Errorf("alias '%s' is reserved", alias)
+	}
+	if aliasBlocked(alias) {
+		return fmt.Errorf("alias contains a blocked word")
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/itcaat/url-shortener-demo/pkg/linkstore"
+)
+
+var (
+	// Максимальное число ссылок в одном пакетном запросе
+	batchMaxItems = getEnvInt("BATCH_MAX_ITEMS", 1000)
+
+	// Ограничение размера тела пакетного запроса
+	batchMaxBodyBytes int64 = 10 << 20
+)
+
+type BatchShortenRequest struct {
+	Items []ShortenRequest `json:"items"`
+}
+
+// BatchItemResult - результат для одного элемента пакета, в порядке запроса
+type BatchItemResult struct {
+	Index  int              `json:"index"`
+	Status int              `json:"status"`
+	Result *ShortenResponse `json:"result,omitempty"`
+	Error  string           `json:"error,omitempty"`
+	Fields []FieldError     `json:"fields,omitempty"`
+}
+
+type BatchShortenResponse struct {
+	Results   []BatchItemResult `json:"results"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+}
+
+// batchShortenHandler создаёт ссылки пачкой. Ошибка одного элемента не прерывает пакет:
+// по каждому элементу возвращается свой статус. Коды занимаются пакетными записями в хранилище.
+func batchShortenHandler(w http.ResponseWriter, r *http.Request) {
+	var req BatchShortenRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, batchMaxBodyBytes)).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if len(req.Items) == 0 {
+		respondError(w, http.StatusBadRequest, "items must not be empty")
+		return
+	}
+	if len(req.Items) > batchMaxItems {
+		respondError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("no more than %d items are allowed per batch", batchMaxItems))
+		return
+	}
+
+	results := make([]BatchItemResult, len(req.Items))
+	plans := make([]*shortenPlan, len(req.Items))
+	claims := make([]*dedupeClaim, len(req.Items))
+	var pending []int
+
+	// Повторы одного URL внутри пакета получают результат первого вхождения
+	firstByKey := map[string]int{}
+	duplicateOf := map[int]int{}
+
+	for i, item := range req.Items {
+		results[i].Index = i
+
+		plan, fieldErrors, err := planShorten(item)
+		if err != nil {
+			log.Printf("[Shortener Service] Failed to prepare link: %v\n", err)
+			results[i].fail(http.StatusInternalServerError, "Failed to save URL")
+			continue
+		}
+		if len(fieldErrors) > 0 {
+			results[i].fail(http.StatusBadRequest, "Validation failed")
+			results[i].Fields = fieldErrors
+			continue
+		}
+		plans[i] = plan
+
+		if plan.dedupe {
+			link := plan.link
+			key := dedupeKey(link.Scope, link.Owner, link.URL)
+			if first, ok := firstByKey[key]; ok {
+				plans[i] = nil
+				duplicateOf[i] = first
+				continue
+			}
+			firstByKey[key] = i
+
+			existing, claim, err := claimDuplicate(link.Scope, link.Owner, link.URL)
+			if err != nil {
+				log.Printf("[Shortener Service] Store error: %v\n", err)
+				results[i].fail(http.StatusInternalServerError, "Database error")
+				plans[i] = nil
+				continue
+			}
+			if existing != nil {
+				results[i].succeed(http.StatusOK, plan.response(existing, true))
+				plans[i] = nil
+				continue
+			}
+			claims[i] = claim
+		}
+
+		pending = append(pending, i)
+	}
+
+	claimBatch(plans, pending, results)
+	rememberBatch(plans, claims, results)
+
+	for i, first := range duplicateOf {
+		if results[first].Result != nil {
+			reused := *results[first].Result
+			reused.Reused = true
+			results[i].succeed(http.StatusOK, reused)
+		} else {
+			results[i].fail(results[first].Status, results[first].Error)
+		}
+	}
+
+	response := BatchShortenResponse{Results: results}
+	for _, result := range results {
+		if result.Result != nil {
+			response.Succeeded++
+		} else {
+			response.Failed++
+		}
+	}
+
+	log.Printf("[Shortener Service] Batch processed: %d succeeded, %d failed\n", response.Succeeded, response.Failed)
+	respondJSON(w, http.StatusOK, response)
+}
+
+// claimBatch занимает коды для всех элементов одной пакетной записью в хранилище. Сгенерированные коды
+// при коллизии перегенерируются в следующем раунде, занятые алиасы сразу получают 409.
+func claimBatch(plans []*shortenPlan, pending []int, results []BatchItemResult) {
+	// Длина генерируемых кодов по форматам доменов элементов пакета
+	lengths := map[*CodeFormat]int{}
+	for _, i := range pending {
+		format := plans[i].domain.Codes
+		lengths[format] = format.CurrentLength()
+	}
+
+	for attempt := 0; attempt < maxClaimAttempts && len(pending) > 0; attempt++ {
+		var entries []linkstore.Entry
+		var claiming, retry []int
+		for _, i := range pending {
+			plan := plans[i]
+			code := plan.alias
+			if code == "" {
+				var err error
+				if code, err = nextCode(plan.domain.Codes, lengths[plan.domain.Codes]); err != nil {
+					log.Printf("[Shortener Service] Error generating short code: %v\n", err)
+					retry = append(retry, i)
+					continue
+				}
+			}
+			plan.link.Code = code
+			entries = append(entries, linkstore.Entry{
+				ID:     linkID(plan.link.Scope, code),
+				Record: &plan.link.LinkRecord,
+				TTL:    plan.keyTTL,
+			})
+			claiming = append(claiming, i)
+		}
+
+		created, err := store.CreateMany(ctx, entries)
+		if err != nil {
+			log.Printf("[Shortener Service] Failed to save batch: %v\n", err)
+			for _, i := range claiming {
+				results[i].fail(http.StatusInternalServerError, "Failed to save URL")
+				plans[i] = nil
+			}
+			claiming = nil
+		}
+
+		collisions := map[*CodeFormat]int{}
+		for n, i := range claiming {
+			switch {
+			case created[n]:
+				// Код занят успешно
+			case plans[i].alias != "":
+				results[i].fail(http.StatusConflict, "Alias is already taken")
+				plans[i] = nil
+			default:
+				collisionRetries.Add(1)
+				collisions[plans[i].domain.Codes]++
+				retry = append(retry, i)
+			}
+		}
+
+		for format := range collisions {
+			if attempt+1 >= collisionsBeforeGrowth && lengths[format] < format.MaxLength && !codeGenerator.Unique() {
+				lengths[format] = growCodeLength(format, lengths[format])
+			}
+		}
+		pending = retry
+	}
+
+	for _, i := range pending {
+		results[i].fail(http.StatusInternalServerError, "Failed to generate short code")
+		plans[i] = nil
+	}
+}
+
+// rememberBatch записывает коды созданных ссылок в занятые ключи дедупликации, снимает захваты
+// элементов, которые создать не удалось, и заполняет результаты
+func rememberBatch(plans []*shortenPlan, claims []*dedupeClaim, results []BatchItemResult) {
+	for i, plan := range plans {
+		if plan == nil {
+			claims[i].release()
+			continue
+		}
+		claims[i].complete(plan.link.Code)
+		results[i].succeed(http.StatusCreated, plan.response(plan.link, false))
+	}
+}
+
+func (r *BatchItemResult) succeed(status int, response ShortenResponse) {
+	r.Status = status
+	r.Result = &response
+}
+
+func (r *BatchItemResult) fail(status int, message string) {
+	r.Status = status
+	r.Error = message
+}
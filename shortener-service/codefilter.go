@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"expvar"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Сколько раз подряд генерировать код заново, если он содержит запрещённое слово
+const maxBlockedRegenerations = 100
+
+// Встроенный список запрещённых слов. Слова с префиксом "=" в алиасах запрещены только
+// целиком (как алиас или его часть между '-' и '_'), чтобы не отклонять analytics или grapes;
+// в сгенерированных кодах все слова ищутся как подстроки - заменить такой код ничего не стоит.
+var defaultBlocklist = []string{
+	"fuck", "shit", "cunt", "bitch", "whore", "slut", "bastard", "asshole", "nigger", "nigga",
+	"faggot", "piss", "twat", "wank", "jizz", "dildo", "pussy", "penis", "vagina", "porn",
+	"nazi", "kkk", "boob", "fart", "blyat", "suka", "huy", "pizd", "govno",
+	"=ass", "=arse", "=cum", "=sex", "=fag", "=anal", "=anus", "=tit", "=tits", "=rape",
+	"=cock", "=dick", "=crap", "=damn", "=hui", "=xer", "=ebat",
+}
+
+// blockedWord - запрещённое слово, приведённое normalizeLeet
+type blockedWord struct {
+	text  string
+	whole bool // в алиасах совпадает только целиком
+}
+
+var (
+	codeBlocklist []blockedWord
+
+	blockedCodes = expvar.NewInt("shortener_blocked_codes")
+)
+
+// Замены leetspeak: цифры, похожие на буквы. l сводится к i, чтобы 1 совпадала с обеими.
+var leetReplacer = strings.NewReplacer(
+	"0", "o", "1", "i", "l", "i", "2", "z", "3", "e", "4", "a",
+	"5", "s", "6", "g", "7", "t", "8", "b", "9", "g",
+)
+
+// initCodeFilter загружает список запрещённых слов: встроенный или из CODE_BLOCKLIST_FILE
+// (по слову в строке, '#' - комментарий), плюс слова из CODE_BLOCKLIST через запятую.
+// CODE_FILTER=false отключает фильтр.
+func initCodeFilter() {
+	if enabled, _ := strconv.ParseBool(getEnv("CODE_FILTER", "true")); !enabled {
+		codeBlocklist = nil
+		log.Println("[Shortener Service] ℹ️  Offensive-word filter for codes disabled")
+		return
+	}
+
+	words := defaultBlocklist
+	if path := getEnv("CODE_BLOCKLIST_FILE", ""); path != "" {
+		var err error
+		if words, err = readBlocklist(path); err != nil {
+			log.Fatalf("Failed to read CODE_BLOCKLIST_FILE: %v", err)
+		}
+	}
+	words = append(words[:len(words):len(words)], strings.Split(getEnv("CODE_BLOCKLIST", ""), ",")...)
+
+	codeBlocklist = nil
+	for _, word := range words {
+		word = strings.ToLower(strings.TrimSpace(word))
+		whole := strings.HasPrefix(word, "=")
+		word = normalizeLeet(strings.TrimPrefix(word, "="))
+		if word == "" {
+			continue
+		}
+		codeBlocklist = append(codeBlocklist, blockedWord{text: word, whole: whole})
+	}
+	log.Printf("[Shortener Service] Offensive-word filter loaded: %d words\n", len(codeBlocklist))
+}
+
+func readBlocklist(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	return words, scanner.Err()
+}
+
+// normalizeLeet приводит код к нижнему регистру и заменяет leetspeak-цифры буквами
+func normalizeLeet(code string) string {
+	return leetReplacer.Replace(strings.ToLower(code))
+}
+
+// codeBlocked сообщает, что сгенерированный код содержит запрещённое слово
+func codeBlocked(code string) bool {
+	normalized := normalizeLeet(code)
+	for _, word := range codeBlocklist {
+		if strings.Contains(normalized, word.text) {
+			return true
+		}
+	}
+	return false
+}
+
+// aliasBlocked сообщает, что алиас содержит запрещённое слово. Разделители '-' и '_'
+// не мешают совпадению (f-u-c-k), но отделяют части для слов, запрещённых только целиком.
+func aliasBlocked(alias string) bool {
+	parts := strings.FieldsFunc(normalizeLeet(alias), func(r rune) bool {
+		return r == '-' || r == '_'
+	})
+	joined := strings.Join(parts, "")
+	for _, word := range codeBlocklist {
+		if !word.whole {
+			if strings.Contains(joined, word.text) {
+				return true
+			}
+			continue
+		}
+		for _, part := range parts {
+			if part == word.text {
+				return true
+			}
+		}
+	}
+	return false
+}
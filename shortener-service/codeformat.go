@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// codeAlphabet - именованный алфавит и его вариант для кодов без учёта регистра
+type codeAlphabet struct {
+	mixed string
+	lower string
+}
+
+// Именованные алфавиты для CODE_ALPHABET; любое другое значение - сами символы алфавита
+var codeAlphabets = map[string]codeAlphabet{
+	"base62": {
+		mixed: "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789",
+		lower: "abcdefghijklmnopqrstuvwxyz0123456789",
+	},
+	// Без похожих символов 0/O/o и 1/l/I - для ссылок, которые переписывают с бумаги.
+	// Нижний регистр не просто сводится из смешанного: из L получилась бы l.
+	"friendly": {
+		mixed: "23456789abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ",
+		lower: "23456789abcdefghijkmnpqrstuvwxyz",
+	},
+}
+
+// Параметры формата в query базового URL домена, например https://go.brand.com?alphabet=friendly&length=5
+var codeFormatParams = map[string]bool{
+	"alphabet":        true,
+	"length":          true,
+	"maxLength":       true,
+	"caseInsensitive": true,
+}
+
+// CodeFormat - формат генерируемых кодов домена
+type CodeFormat struct {
+	Alphabet  string
+	MinLength int
+	MaxLength int
+	// Коды хранятся в нижнем регистре, а входящие коды приводятся к нему,
+	// поэтому go.brand.com/AbC и go.brand.com/abc - одна ссылка
+	CaseInsensitive bool
+
+	// Текущая длина генерируемых кодов, увеличивается при частых коллизиях
+	length atomic.Int64
+}
+
+// newCodeFormat строит формат по параметрам домена; незаданные берутся из
+// CODE_ALPHABET, CODE_LENGTH, CODE_MAX_LENGTH и CODE_CASE_INSENSITIVE
+func newCodeFormat(params url.Values) (*CodeFormat, error) {
+	for name := range params {
+		if !codeFormatParams[name] {
+			return nil, fmt.Errorf("unknown parameter %q", name)
+		}
+	}
+	param := func(name, env, fallback string) string {
+		if value := params.Get(name); value != "" {
+			return value
+		}
+		return getEnv(env, fallback)
+	}
+
+	minLength, err := strconv.Atoi(param("length", "CODE_LENGTH", "6"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid code length: %w", err)
+	}
+	maxLength, err := strconv.Atoi(param("maxLength", "CODE_MAX_LENGTH", "10"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid max code length: %w", err)
+	}
+	if minLength < 1 || maxLength < minLength || maxLength > aliasMaxLength {
+		return nil, fmt.Errorf("code length must satisfy 1 <= length <= maxLength <= %d", aliasMaxLength)
+	}
+	caseInsensitive, err := strconv.ParseBool(param("caseInsensitive", "CODE_CASE_INSENSITIVE", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid caseInsensitive flag: %w", err)
+	}
+
+	alphabet := param("alphabet", "CODE_ALPHABET", "base62")
+	switch preset, ok := codeAlphabets[alphabet]; {
+	case ok && caseInsensitive:
+		alphabet = preset.lower
+	case ok:
+		alphabet = preset.mixed
+	case caseInsensitive:
+		alphabet = strings.ToLower(alphabet)
+	}
+	if alphabet, err = normalizeAlphabet(alphabet); err != nil {
+		return nil, err
+	}
+
+	format := &CodeFormat{
+		Alphabet:        alphabet,
+		MinLength:       minLength,
+		MaxLength:       maxLength,
+		CaseInsensitive: caseInsensitive,
+	}
+	format.length.Store(int64(minLength))
+	return format, nil
+}
+
+// normalizeAlphabet убирает повторы символов (например, после приведения к нижнему регистру)
+// и проверяет, что коды из алфавита пройдут как путь ссылки
+func normalizeAlphabet(alphabet string) (string, error) {
+	seen := map[rune]bool{}
+	var result strings.Builder
+	for _, c := range alphabet {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return "", fmt.Errorf("code alphabet may contain only latin letters and digits, got %q", c)
+		}
+		if !seen[c] {
+			seen[c] = true
+			result.WriteRune(c)
+		}
+	}
+	if result.Len() < 2 {
+		return "", fmt.Errorf("code alphabet must contain at least 2 distinct characters")
+	}
+	return result.String(), nil
+}
+
+// Canonical приводит код к виду, в котором он хранится
+func (f *CodeFormat) Canonical(code string) string {
+	if f.CaseInsensitive {
+		return strings.ToLower(code)
+	}
+	return code
+}
+
+// CurrentLength возвращает текущую длину генерируемых кодов
+func (f *CodeFormat) CurrentLength() int {
+	return int(f.length.Load())
+}
+
+// grow увеличивает длину кода, если её ещё не увеличил другой запрос; возвращает новую длину
+func (f *CodeFormat) grow(from int) (int, bool) {
+	grown := f.length.CompareAndSwap(int64(from), int64(from+1))
+	return f.CurrentLength(), grown
+}
+
+// ValidateCode проверяет код, который занимается в пространстве домена в обход генератора (при импорте).
+// Код должен проходить как путь ссылки и не пересекаться с маршрутами сервисов. Ограничения
+// пользовательских алиасов (минимальная длина, запрещённые слова) к нему не применяются:
+// перенесённые из другого сервиса коды уже опубликованы и должны сохраниться как есть.
+func (f *CodeFormat) ValidateCode(code string) error {
+	if len(code) > aliasMaxLength {
+		return fmt.Errorf("code must not exceed %d characters", aliasMaxLength)
+	}
+	if !aliasPattern.MatchString(code) {
+		return fmt.Errorf("code may contain only letters, digits, '-' and '_' and must start with a letter or digit")
+	}
+	if reservedAliases[strings.ToLower(f.Canonical(code))] {
+		return fmt.Errorf("code '%s' is reserved", code)
+	}
+	return nil
+}
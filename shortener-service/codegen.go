@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	strategyRandom  = "random"
+	strategyCounter = "counter"
+
+	// После стольких коллизий подряд пространство кодов считается заполненным и длина растёт
+	collisionsBeforeGrowth = 3
+)
+
+var (
+	maxClaimAttempts = getEnvInt("CODE_MAX_ATTEMPTS", 10)
+
+	// Стратегия генерации кодов, выбирается в initCodeGenerator
+	codeGenerator CodeGenerator = randomGenerator{}
+
+	collisionRetries = expvar.NewInt("shortener_collision_retries")
+	codeLengthGrowth = expvar.NewInt("shortener_code_length_growths")
+
+	errCodeSpaceExhausted = errors.New("failed to find a free short code")
+)
+
+func init() {
+	// Текущая длина кодов по доменам
+	expvar.Publish("shortener_code_length", expvar.Func(func() any {
+		lengths := map[string]int{}
+		if defaultDomain.Codes != nil {
+			lengths[defaultDomain.Name] = defaultDomain.Codes.CurrentLength()
+		}
+		for name, domain := range brandedDomains {
+			lengths[name] = domain.Codes.CurrentLength()
+		}
+		return lengths
+	}))
+}
+
+// claimCode атомарно занимает link.Code в пространстве домена, сразу записывая ссылку;
+// false означает, что код уже занят
+func claimCode(link *Link, expiration time.Duration) (bool, error) {
+	return store.Create(ctx, linkID(link.Scope, link.Code), &link.LinkRecord, expiration)
+}
+
+// CodeGenerator выдаёт кандидатов в короткие коды
+type CodeGenerator interface {
+	// Next возвращает новый код из алфавита формата длиной не меньше length
+	Next(format *CodeFormat, length int) (string, error)
+	// Unique сообщает, что генератор не повторяет коды: коллизии возможны только
+	// с алиасами и импортированными кодами, поэтому длину кода при них не увеличиваем
+	Unique() bool
+}
+
+// initCodeGenerator выбирает стратегию из CODE_STRATEGY: random (по умолчанию) или counter
+func initCodeGenerator() {
+	switch strategy := getEnv("CODE_STRATEGY", strategyRandom); strategy {
+	case strategyRandom:
+		codeGenerator = randomGenerator{}
+	case strategyCounter:
+		generator, err := newCounterGenerator(getEnv("CODE_OBFUSCATION", obfuscationFeistel), getEnv("CODE_SECRET", ""))
+		if err != nil {
+			log.Fatalf("Invalid code generator configuration: %v", err)
+		}
+		codeGenerator = generator
+	default:
+		log.Fatalf("Unknown CODE_STRATEGY %q (expected %s or %s)", strategy, strategyRandom, strategyCounter)
+	}
+}
+
+// claimGeneratedCode генерирует код и занимает его, повторяя попытки при коллизиях.
+// Код строится по формату домена ссылки. Если у случайных кодов коллизии идут подряд,
+// длина кода увеличивается (вплоть до максимальной длины формата). Занятый код записывается в link.Code.
+func claimGeneratedCode(link *Link, expiration time.Duration) error {
+	format := domainForScope(link.Scope).Codes
+	length := format.CurrentLength()
+	collisions := 0
+
+	for attempt := 0; attempt < maxClaimAttempts; attempt++ {
+		code, err := nextCode(format, length)
+		if err != nil {
+			return fmt.Errorf("generate short code: %w", err)
+		}
+
+		link.Code = code
+		claimed, err := claimCode(link, expiration)
+		if err != nil {
+			return fmt.Errorf("claim short code: %w", err)
+		}
+		if claimed {
+			return nil
+		}
+
+		collisionRetries.Add(1)
+		collisions++
+		if collisions >= collisionsBeforeGrowth && length < format.MaxLength && !codeGenerator.Unique() {
+			length = growCodeLength(format, length)
+			collisions = 0
+		}
+	}
+
+	link.Code = ""
+	return errCodeSpaceExhausted
+}
+
+// nextCode генерирует код, незаметно для клиента пропуская коды с запрещёнными словами
+func nextCode(format *CodeFormat, length int) (string, error) {
+	for i := 0; i < maxBlockedRegenerations; i++ {
+		code, err := codeGenerator.Next(format, length)
+		if err != nil || !codeBlocked(code) {
+			return code, err
+		}
+		blockedCodes.Add(1)
+	}
+	return "", errCodeSpaceExhausted
+}
+
+// growCodeLength увеличивает длину кодов формата и учитывает это в метриках
+func growCodeLength(format *CodeFormat, from int) int {
+	length, grown := format.grow(from)
+	if grown {
+		codeLengthGrowth.Add(1)
+		log.Printf("[Shortener Service] Too many collisions, growing code length to %d\n", length)
+	}
+	return length
+}
+
+// randomGenerator выдаёт случайные коды из crypto/rand; уникальность обеспечивается повтором при коллизии
+type randomGenerator struct{}
+
+func (randomGenerator) Unique() bool {
+	return false
+}
+
+func (randomGenerator) Next(format *CodeFormat, length int) (string, error) {
+	return generateShortCode(format.Alphabet, length)
+}
+
+// generateShortCode читает случайные байты пачкой и отбрасывает значения не меньше
+// наибольшего кратного len(alphabet), чтобы символы распределялись равномерно
+func generateShortCode(alphabet string, length int) (string, error) {
+	limit := 256 - 256%len(alphabet)
+
+	result := make([]byte, 0, length)
+	buf := make([]byte, length+length/2)
+	for len(result) < length {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) >= limit {
+				continue
+			}
+			result = append(result, alphabet[int(b)%len(alphabet)])
+			if len(result) == length {
+				break
+			}
+		}
+	}
+
+	return string(result), nil
+}
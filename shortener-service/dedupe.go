@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itcaat/url-shortener-demo/pkg/linkstore"
+)
+
+const maxOwnerLength = 64
+
+const (
+	// dedupePendingPrefix помечает ключ, занятый запросом, который ещё создаёт ссылку
+	dedupePendingPrefix = "pending:"
+	// Сколько ждать, пока занявший ключ запрос создаст ссылку
+	dedupeWaitAttempts = 40
+	dedupeWaitInterval = 25 * time.Millisecond
+	// Сколько раз перечитывать ключ, если его успели изменить между чтением и захватом
+	dedupeClaimAttempts = 5
+)
+
+var errDedupeContended = errors.New("dedupe index is contended")
+
+// Опциональный режим: повторный запрос с тем же URL от того же владельца возвращает существующую ссылку
+var deduplicateURLs, _ = strconv.ParseBool(getEnv("DEDUPLICATE_URLS", "false"))
+
+// dedupeKey возвращает ключ обратного индекса "хэш нормализованного URL -> код" для владельца в домене
+func dedupeKey(scope, owner, destination string) string {
+	sum := sha256.Sum256([]byte(destination))
+	return "dedupe:" + linkID(scope, owner) + ":" + hex.EncodeToString(sum[:])
+}
+
+// dedupable сообщает, может ли ссылка участвовать в дедупликации: это бессрочная включённая ссылка
+// без пароля, лимита переходов, окна активности, правил маршрутизации, A/B-теста, передачи параметров
+// и пути и собственного кода перенаправления. Одни и те же условия проверяются при создании и при повторном использовании.
+func dedupable(record *LinkRecord) bool {
+	return record.ExpiresAt == nil && record.State != stateDisabled && record.PasswordHash == "" &&
+		record.MaxClicks == 0 && record.ActiveFrom == nil && record.ActiveUntil == nil &&
+		len(record.Rules) == 0 && len(record.Variants) == 0 &&
+		record.QueryPassthrough == "" && !record.PathPassthrough && record.RedirectStatus == 0
+}
+
+// dedupeClaim - ключ дедупликации, занятый запросом на время создания ссылки
+type dedupeClaim struct {
+	key    string
+	marker string
+}
+
+// claimDuplicate атомарно занимает ключ дедупликации до создания ссылки, чтобы параллельные запросы
+// с одним URL не создали по ссылке каждый. Если ключ указывает на подходящую ссылку, в том числе
+// созданную запросом, который занял ключ раньше, возвращается она, а ключ не занимается.
+func claimDuplicate(scope, owner, destination string) (*Link, *dedupeClaim, error) {
+	key := dedupeKey(scope, owner, destination)
+	marker, err := pendingMarker()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for attempt := 0; attempt < dedupeClaimAttempts; attempt++ {
+		current, err := awaitDuplicate(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if current != "" && !strings.HasPrefix(current, dedupePendingPrefix) {
+			link, found, err := duplicateLink(scope, destination, current)
+			if err != nil {
+				return nil, nil, err
+			}
+			if found {
+				return link, nil, nil
+			}
+		}
+
+		// Устаревший код и брошенная пометка заменяются; проигравший захват перечитывает ключ
+		swapped, err := store.SwapRef(ctx, key, current, marker)
+		if err != nil {
+			return nil, nil, err
+		}
+		if swapped {
+			return nil, &dedupeClaim{key: key, marker: marker}, nil
+		}
+	}
+	return nil, nil, errDedupeContended
+}
+
+// awaitDuplicate читает ключ дедупликации, дожидаясь, пока занявший его запрос создаст ссылку.
+// Пометка, не сменившаяся за время ожидания, возвращается как есть: такой захват считается брошенным.
+func awaitDuplicate(key string) (string, error) {
+	var current string
+	for wait := 0; wait < dedupeWaitAttempts; wait++ {
+		var err error
+		current, err = store.GetRef(ctx, key)
+		if err == linkstore.ErrNotFound {
+			return "", nil
+		} else if err != nil {
+			return "", err
+		}
+		if !strings.HasPrefix(current, dedupePendingPrefix) {
+			return current, nil
+		}
+		time.Sleep(dedupeWaitInterval)
+	}
+	return current, nil
+}
+
+// duplicateLink загружает ссылку из индекса дедупликации. Индекс может устареть,
+// поэтому найденная ссылка перепроверяется: тот же URL и всё ещё подходит под дедупликацию.
+func duplicateLink(scope, destination, code string) (*Link, bool, error) {
+	link, err := loadLink(scope, code)
+	if err == errLinkNotFound {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	if link.URL != destination || !dedupable(&link.LinkRecord) {
+		return nil, false, nil
+	}
+	return link, true, nil
+}
+
+// pendingMarker возвращает уникальную пометку захвата, чтобы запрос снимал или заменял только свою
+func pendingMarker() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return dedupePendingPrefix + hex.EncodeToString(buf), nil
+}
+
+// complete заменяет пометку захвата кодом созданной ссылки; nil-захват ничего не делает
+func (c *dedupeClaim) complete(code string) {
+	if c == nil {
+		return
+	}
+	swapped, err := store.SwapRef(ctx, c.key, c.marker, code)
+	if err != nil {
+		log.Printf("[Shortener Service] Failed to save dedupe index: %v\n", err)
+	} else if !swapped {
+		log.Printf("[Shortener Service] Dedupe claim for code '%s' was taken over by another request\n", code)
+	}
+}
+
+// release снимает захват, если ссылку создать не удалось; nil-захват ничего не делает
+func (c *dedupeClaim) release() {
+	if c == nil {
+		return
+	}
+	if err := store.DeleteRef(ctx, c.key, c.marker); err != nil {
+		log.Printf("[Shortener Service] Failed to release dedupe index: %v\n", err)
+	}
+}
+
+// forgetDuplicate удаляет код из обратного индекса, если индекс всё ещё указывает на него
+func forgetDuplicate(scope, owner, destination, code string) error {
+	return store.DeleteRef(ctx, dedupeKey(scope, owner, destination), code)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/itcaat/url-shortener-demo/pkg/linkstore"
+)
+
+// useDeduplication включает DEDUPLICATE_URLS на время теста
+func useDeduplication(t *testing.T) {
+	previous := deduplicateURLs
+	deduplicateURLs = true
+	t.Cleanup(func() { deduplicateURLs = previous })
+}
+
+// countLinks возвращает число ссылок в хранилище
+func countLinks(t *testing.T) int {
+	t.Helper()
+	n := 0
+	err := store.Scan(ctx, func(id string, record *linkstore.Record) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestShortenDeduplicatesConcurrentRequests(t *testing.T) {
+	testService(t)
+	useDeduplication(t)
+
+	const requests = 20
+	responses := make([]ShortenResponse, requests)
+	statuses := make([]int, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := serve("POST", "/shorten", `{"url": "https://example.com/same", "owner": "team"}`)
+			statuses[i] = w.Code
+			decodeBody(t, w, &responses[i])
+		}(i)
+	}
+	wg.Wait()
+
+	created := 0
+	for i, response := range responses {
+		switch statuses[i] {
+		case http.StatusCreated:
+			created++
+		case http.StatusOK:
+			if !response.Reused {
+				t.Errorf("response %d: 200 without reused", i)
+			}
+		default:
+			t.Fatalf("response %d: status %d", i, statuses[i])
+		}
+		if response.ShortCode != responses[0].ShortCode {
+			t.Errorf("response %d: code %q, want %q", i, response.ShortCode, responses[0].ShortCode)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d links created, want 1", created)
+	}
+	if n := countLinks(t); n != 1 {
+		t.Errorf("store holds %d links, want 1", n)
+	}
+	code, err := store.GetRef(ctx, dedupeKey("", "team", "https://example.com/same"))
+	if err != nil || code != responses[0].ShortCode {
+		t.Errorf("dedupe index = %q, %v; want %q", code, err, responses[0].ShortCode)
+	}
+}
+
+func TestShortenReplacesStaleDedupeEntries(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"deleted link", "gone"},
+		{"abandoned claim", dedupePendingPrefix + "crashed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testService(t)
+			useDeduplication(t)
+			key := dedupeKey("", "", "https://example.com/page")
+			if err := store.SetRef(context.Background(), key, tt.value); err != nil {
+				t.Fatal(err)
+			}
+
+			w := serve("POST", "/shorten", `{"url": "https://example.com/page"}`)
+			if w.Code != http.StatusCreated {
+				t.Fatalf("status = %d, want 201", w.Code)
+			}
+			var response ShortenResponse
+			decodeBody(t, w, &response)
+			if code, _ := store.GetRef(ctx, key); code != response.ShortCode {
+				t.Errorf("dedupe index = %q, want %q", code, response.ShortCode)
+			}
+
+			w = serve("POST", "/shorten", `{"url": "https://example.com/page"}`)
+			var again ShortenResponse
+			decodeBody(t, w, &again)
+			if w.Code != http.StatusOK || again.ShortCode != response.ShortCode {
+				t.Errorf("repeat = %d %q, want 200 %q", w.Code, again.ShortCode, response.ShortCode)
+			}
+		})
+	}
+}
+
+func TestShortenBatchDeduplicates(t *testing.T) {
+	testService(t)
+	useDeduplication(t)
+
+	w := serve("POST", "/shorten/batch", `{"items": [
+		{"url": "https://example.com/a"},
+		{"url": "https://example.com/a"},
+		{"url": "https://example.com/b"}
+	]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	var response BatchShortenResponse
+	decodeBody(t, w, &response)
+	if response.Succeeded != 3 || response.Results[0].Result.ShortCode != response.Results[1].Result.ShortCode {
+		t.Fatalf("batch = %+v, want the repeated URL to reuse the first code", response)
+	}
+	for i, url := range []string{"https://example.com/a", "https://example.com/b"} {
+		code, err := store.GetRef(ctx, dedupeKey("", "", url))
+		if err != nil || code != response.Results[i*2].Result.ShortCode {
+			t.Errorf("dedupe index for %s = %q, %v", url, code, err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Domain описывает домен, от которого строятся короткие ссылки
+type Domain struct {
+	Name    string // хост без порта, по нему выбирается домен в запросе
+	BaseURL string // схема, хост и необязательный префикс пути без завершающего "/"
+	Scope   string // пространство кодов в Redis; пустое для домена по умолчанию
+	Codes   *CodeFormat
+}
+
+var (
+	defaultDomain  Domain
+	brandedDomains = map[string]Domain{}
+
+	// Формат кодов по умолчанию (CODE_*) для доменов, убранных из конфигурации
+	fallbackCodeFormat *CodeFormat
+)
+
+// initDomains читает PUBLIC_BASE_URL и BRANDED_DOMAINS.
+// У брендированных доменов собственное пространство кодов: go.brand-a.com/x и go.brand-b.com/x - разные ссылки.
+// Формат кодов домена задаётся параметрами базового URL (см. newCodeFormat).
+func initDomains() {
+	var err error
+	fallbackCodeFormat, err = newCodeFormat(nil)
+	if err != nil {
+		log.Fatalf("Invalid code format: %v", err)
+	}
+
+	defaultDomain, err = parseDomain(getEnv("PUBLIC_BASE_URL", "http://localhost:3002"))
+	if err != nil {
+		log.Fatalf("Invalid PUBLIC_BASE_URL: %v", err)
+	}
+
+	for _, baseURL := range strings.Split(getEnv("BRANDED_DOMAINS", ""), ",") {
+		if baseURL = strings.TrimSpace(baseURL); baseURL == "" {
+			continue
+		}
+		domain, err := parseDomain(baseURL)
+		if err != nil {
+			log.Fatalf("Invalid BRANDED_DOMAINS entry %q: %v", baseURL, err)
+		}
+		if domain.Name == defaultDomain.Name {
+			continue
+		}
+		domain.Scope = domain.Name
+		brandedDomains[domain.Name] = domain
+	}
+
+	// Ссылки на собственные домены создали бы петлю перенаправлений. Сравниваются хост и порт
+	// базового URL: другие сервисы на том же хосте (например, localhost) остаются допустимыми.
+	redirectHosts = append(redirectHosts, defaultDomain.hostPort())
+	for _, domain := range brandedDomains {
+		redirectHosts = append(redirectHosts, domain.hostPort())
+	}
+}
+
+// hostPort возвращает хост и порт базового URL домена; без явного порта - порт схемы по умолчанию
+func (d Domain) hostPort() string {
+	u, err := url.Parse(d.BaseURL)
+	if err != nil {
+		return d.Name
+	}
+	port := u.Port()
+	if port == "" {
+		port = defaultPorts[u.Scheme]
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+func parseDomain(baseURL string) (Domain, error) {
+	u, err := url.Parse(strings.TrimSpace(baseURL))
+	if err != nil {
+		return Domain{}, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return Domain{}, fmt.Errorf("scheme must be http or https")
+	}
+	if u.Hostname() == "" {
+		return Domain{}, fmt.Errorf("host is empty")
+	}
+	codes, err := newCodeFormat(u.Query())
+	if err != nil {
+		return Domain{}, fmt.Errorf("code format: %w", err)
+	}
+	u.Host = strings.ToLower(u.Host)
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawQuery, u.Fragment = "", ""
+
+	return Domain{
+		Name:    u.Hostname(),
+		BaseURL: u.String(),
+		Codes:   codes,
+	}, nil
+}
+
+// resolveDomain выбирает домен по имени из запроса; пустое имя - домен по умолчанию
+func resolveDomain(name string) (Domain, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || name == defaultDomain.Name {
+		return defaultDomain, true
+	}
+	domain, ok := brandedDomains[name]
+	return domain, ok
+}
+
+// domainForScope возвращает домен по пространству кодов ссылки
+func domainForScope(scope string) Domain {
+	if scope == "" {
+		return defaultDomain
+	}
+	if domain, ok := brandedDomains[scope]; ok {
+		return domain
+	}
+	// Домен убран из конфигурации, но ссылки на нём ещё хранятся
+	return Domain{Name: scope, BaseURL: "https://" + scope, Scope: scope, Codes: fallbackCodeFormat}
+}
+
+// ShortURL строит публичную короткую ссылку для кода
+func (d Domain) ShortURL(code string) string {
+	return d.BaseURL + "/" + code
+}
+
+// linkID возвращает идентификатор ссылки с учётом домена: "code" или "domain/code"
+func linkID(scope, code string) string {
+	if scope == "" {
+		return code
+	}
+	return scope + "/" + code
+}
+
+// splitLinkID разбирает id ссылки на пространство домена и код
+func splitLinkID(id string) (scope, code string) {
+	if i := strings.LastIndexByte(id, '/'); i >= 0 {
+		return id[:i], id[i+1:]
+	}
+	return "", id
+}
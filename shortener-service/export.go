@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+)
+
+// exportLinks потоково выгружает все ссылки хранилища в CSV или NDJSON.
+// scope ограничивает выгрузку одним доменом, nil - все домены. Хеши паролей
+// выгружаются только с withPasswords: по ним пароли можно подбирать офлайн.
+func exportLinks(w io.Writer, format string, scope *string, withPasswords bool, flush func()) (int, error) {
+	var csvWriter *csv.Writer
+	encoder := json.NewEncoder(w)
+	if format == formatCSV {
+		csvWriter = csv.NewWriter(w)
+		columns := exportColumns
+		if withPasswords {
+			columns = append(columns[:len(columns):len(columns)], exportPasswordColumn)
+		}
+		if err := csvWriter.Write(columns); err != nil {
+			return 0, err
+		}
+	}
+
+	// Данные отдаются клиенту пачками, не дожидаясь конца выгрузки
+	flushBatch := func() error {
+		if csvWriter != nil {
+			csvWriter.Flush()
+			if err := csvWriter.Error(); err != nil {
+				return err
+			}
+		}
+		if flush != nil {
+			flush()
+		}
+		return nil
+	}
+
+	exported := 0
+	err := store.Scan(ctx, func(id string, record *LinkRecord) error {
+		linkScope, code := splitLinkID(id)
+		if scope != nil && linkScope != *scope {
+			return nil
+		}
+
+		data := newLinkExport(&Link{Scope: linkScope, Code: code, LinkRecord: *record})
+		if !withPasswords {
+			data.PasswordHash = ""
+		}
+		var err error
+		if csvWriter != nil {
+			err = csvWriter.Write(data.csvRecord(withPasswords))
+		} else {
+			err = encoder.Encode(data)
+		}
+		if err != nil {
+			return err
+		}
+
+		exported++
+		if exported%importChunkSize == 0 {
+			return flushBatch()
+		}
+		return nil
+	})
+	if err != nil {
+		return exported, err
+	}
+	return exported, flushBatch()
+}
+
+func newLinkExport(link *Link) LinkExport {
+	data := LinkExport{
+		Code:             link.Code,
+		Domain:           domainForScope(link.Scope).Name,
+		Destination:      link.URL,
+		Title:            link.Title,
+		Owner:            link.Owner,
+		Tags:             link.Tags,
+		State:            link.State,
+		ExpiresAt:        link.ExpiresAt,
+		Protected:        link.PasswordHash != "",
+		PasswordHash:     link.PasswordHash,
+		MaxClicks:        link.MaxClicks,
+		ActiveFrom:       link.ActiveFrom,
+		ActiveUntil:      link.ActiveUntil,
+		FallbackURL:      link.FallbackURL,
+		Rules:            link.Rules,
+		TimeZone:         link.TimeZone,
+		Variants:         link.Variants,
+		QueryPassthrough: link.QueryPassthrough,
+		PathPassthrough:  link.PathPassthrough,
+		RedirectStatus:   link.RedirectStatus,
+	}
+	if !link.CreatedAt.IsZero() {
+		data.CreatedAt = &link.CreatedAt
+	}
+	return data
+}
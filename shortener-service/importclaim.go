@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/itcaat/url-shortener-demo/pkg/linkstore"
+)
+
+// importLinks проверяет и записывает строки импорта согласно политике конфликтов.
+// В режиме dry-run выполняются валидация и поиск конфликтов без записи.
+func importLinks(rows []*importRow, opts importOptions) (*ImportReport, error) {
+	report := &ImportReport{DryRun: opts.DryRun, OnConflict: opts.OnConflict, Total: len(rows), Rows: []ImportRowResult{}}
+	defer func() {
+		sort.SliceStable(report.Rows, func(i, j int) bool {
+			return report.Rows[i].Line < report.Rows[j].Line
+		})
+	}()
+
+	var valid []*importRow
+	for _, row := range rows {
+		if row.Err != nil {
+			report.addRow(row, "invalid", row.Err.Error(), nil)
+			continue
+		}
+		if fieldErrors := prepareImportRow(row, opts.Domain); len(fieldErrors) > 0 {
+			report.addRow(row, "invalid", "Validation failed", fieldErrors)
+			continue
+		}
+		valid = append(valid, row)
+	}
+
+	existing, err := findExistingCodes(valid)
+	if err != nil {
+		return nil, err
+	}
+
+	// Политика fail: при любом конфликте ничего не записываем
+	if opts.OnConflict == conflictFail && len(existing) > 0 {
+		report.Aborted = true
+		for _, row := range valid {
+			if existing[row] {
+				report.addRow(row, "conflict", "Code already exists", nil)
+			}
+		}
+		return report, nil
+	}
+
+	var fresh, conflicting []*importRow
+	for _, row := range valid {
+		switch {
+		case !existing[row]:
+			fresh = append(fresh, row)
+		case opts.OnConflict == conflictOverwrite:
+			conflicting = append(conflicting, row)
+		default:
+			report.Skipped++
+			report.addRow(row, "skipped", "Code already exists", nil)
+		}
+	}
+
+	if opts.DryRun {
+		report.Created = len(fresh)
+		report.Overwritten = len(conflicting)
+		return report, nil
+	}
+
+	for start := 0; start < len(fresh); start += importChunkSize {
+		end := min(start+importChunkSize, len(fresh))
+		raced, err := createImportedLinks(fresh[start:end], report)
+		if err != nil {
+			return nil, err
+		}
+		// Код заняли параллельно между проверкой и записью - применяем политику конфликта
+		for _, row := range raced {
+			if opts.OnConflict == conflictOverwrite {
+				conflicting = append(conflicting, row)
+			} else {
+				report.Skipped++
+				report.addRow(row, "skipped", "Code already exists", nil)
+			}
+		}
+	}
+
+	for start := 0; start < len(conflicting); start += importChunkSize {
+		end := min(start+importChunkSize, len(conflicting))
+		if err := overwriteImportedLinks(conflicting[start:end], report); err != nil {
+			return nil, err
+		}
+	}
+
+	return report, nil
+}
+
+// findExistingCodes находит строки, чьи коды уже заняты в хранилище или повторяются в самом файле
+func findExistingCodes(rows []*importRow) (map[*importRow]bool, error) {
+	existing := map[*importRow]bool{}
+	seen := map[string]bool{}
+
+	var ids []string
+	var checked []*importRow
+	for _, row := range rows {
+		if row.Link.Code == "" {
+			continue
+		}
+		id := linkID(row.Link.Scope, row.Link.Code)
+		if seen[id] {
+			existing[row] = true
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+		checked = append(checked, row)
+	}
+
+	// Нечитаемая запись тоже занимает код
+	records, err := store.GetMany(ctx, ids)
+	var unreadable *linkstore.UnreadableError
+	if errors.As(err, &unreadable) {
+		broken := make(map[string]bool, len(unreadable.IDs))
+		for _, id := range unreadable.IDs {
+			broken[id] = true
+		}
+		for i, id := range ids {
+			if broken[id] {
+				existing[checked[i]] = true
+			}
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	for i, record := range records {
+		if record != nil {
+			existing[checked[i]] = true
+		}
+	}
+	return existing, nil
+}
+
+// createImportedLinks записывает новые ссылки одной пакетной записью.
+// Возвращает строки, коды которых успели занять параллельно.
+func createImportedLinks(rows []*importRow, report *ImportReport) ([]*importRow, error) {
+	var entries []linkstore.Entry
+	var claiming []*importRow
+	for _, row := range rows {
+		if row.Link.Code == "" {
+			if err := claimGeneratedCode(row.Link, row.KeyTTL); err != nil {
+				report.addRow(row, "error", "Failed to generate short code", nil)
+				continue
+			}
+			report.Created++
+			continue
+		}
+		entries = append(entries, linkstore.Entry{
+			ID:     linkID(row.Link.Scope, row.Link.Code),
+			Record: &row.Link.LinkRecord,
+			TTL:    row.KeyTTL,
+		})
+		claiming = append(claiming, row)
+	}
+
+	created, err := store.CreateMany(ctx, entries)
+	if err != nil {
+		return nil, err
+	}
+
+	var raced []*importRow
+	for i, row := range claiming {
+		if !created[i] {
+			raced = append(raced, row)
+			continue
+		}
+		report.Created++
+	}
+	return raced, nil
+}
+
+// overwriteImportedLinks заменяет существующие ссылки; индексы и счётчик переходов хранилище сбрасывает само,
+// а прежний адрес убирается из индекса дедупликации
+func overwriteImportedLinks(rows []*importRow, report *ImportReport) error {
+	ids := make([]string, len(rows))
+	for i, row := range rows {
+		ids[i] = linkID(row.Link.Scope, row.Link.Code)
+	}
+	// Нечитаемые прежние записи просто заменяются: их адрес неизвестен, убрать его из дедупликации нельзя
+	previous, err := store.GetMany(ctx, ids)
+	var unreadable *linkstore.UnreadableError
+	if err != nil && !errors.As(err, &unreadable) {
+		return err
+	}
+
+	for i, row := range rows {
+		if err := store.Put(ctx, ids[i], &row.Link.LinkRecord, row.KeyTTL); err != nil {
+			return err
+		}
+		if old := previous[i]; old != nil {
+			if err := forgetDuplicate(row.Link.Scope, old.Owner, old.URL, row.Link.Code); err != nil {
+				return err
+			}
+		}
+		report.Overwritten++
+		report.addRow(row, "overwritten", "", nil)
+	}
+	return nil
+}
+
+func (r *ImportReport) addRow(row *importRow, status, message string, fields []FieldError) {
+	if status == "invalid" || status == "conflict" || status == "error" {
+		r.Failed++
+	}
+	result := ImportRowResult{
+		Line:   row.Line,
+		Code:   row.Data.Code,
+		Domain: row.Data.Domain,
+		Status: status,
+		Error:  message,
+		Fields: fields,
+	}
+	if row.Link != nil {
+		result.Code = row.Link.Code
+	}
+	r.Rows = append(r.Rows, result)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/itcaat/url-shortener-demo/pkg/linkstore"
+)
+
+// prepareImportRow валидирует строку и готовит запись ссылки
+func prepareImportRow(row *importRow, defaultDomainName string) []FieldError {
+	data := row.Data
+	var fieldErrors []FieldError
+
+	destination, err := normalizeURL(data.Destination)
+	if err != nil {
+		fieldErrors = append(fieldErrors, FieldError{Field: "destination", Message: err.Error()})
+	}
+
+	domainName := data.Domain
+	if domainName == "" {
+		domainName = defaultDomainName
+	}
+	domain, ok := resolveDomain(domainName)
+	if !ok {
+		fieldErrors = append(fieldErrors, FieldError{Field: "domain", Message: fmt.Sprintf("domain '%s' is not configured", domainName)})
+	}
+
+	// Импортируемый код проверяется форматом домена, а не правилами алиасов (см. ValidateCode)
+	if data.Code != "" && ok {
+		if err := domain.Codes.ValidateCode(data.Code); err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: "code", Message: err.Error()})
+		}
+	}
+
+	if len(data.Owner) > maxOwnerLength {
+		fieldErrors = append(fieldErrors, FieldError{Field: "owner", Message: fmt.Sprintf("owner must not exceed %d characters", maxOwnerLength)})
+	}
+
+	title, err := normalizeTitle(data.Title)
+	if err != nil {
+		fieldErrors = append(fieldErrors, FieldError{Field: "title", Message: err.Error()})
+	}
+
+	tags, err := normalizeTags(data.Tags)
+	if err != nil {
+		fieldErrors = append(fieldErrors, FieldError{Field: "tags", Message: err.Error()})
+	}
+
+	state := strings.ToLower(data.State)
+	switch state {
+	case "", statusActive:
+		state = ""
+	case stateDisabled:
+	default:
+		fieldErrors = append(fieldErrors, FieldError{Field: "state", Message: "state must be 'active' or 'disabled'"})
+	}
+
+	if data.PasswordHash != "" && !linkstore.ValidPasswordHash(data.PasswordHash) {
+		fieldErrors = append(fieldErrors, FieldError{Field: "passwordHash", Message: "passwordHash has an unsupported format"})
+	}
+	// Без хеша защищённая ссылка стала бы открытой
+	if data.Protected && data.PasswordHash == "" {
+		fieldErrors = append(fieldErrors, FieldError{Field: "passwordHash", Message: "passwordHash is required for protected links (export with -include-password-hashes)"})
+	}
+
+	if err := validateMaxClicks(data.MaxClicks); err != nil {
+		fieldErrors = append(fieldErrors, FieldError{Field: "maxClicks", Message: err.Error()})
+	}
+
+	// Окно активности переносится как есть, даже уже закончившееся
+	activeFrom, activeUntil := scheduleTime(data.ActiveFrom), scheduleTime(data.ActiveUntil)
+	var fallbackURL string
+	if data.FallbackURL != "" {
+		if fallbackURL, err = normalizeURL(data.FallbackURL); err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: "fallbackUrl", Message: err.Error()})
+		}
+	}
+	fieldErrors = append(fieldErrors, validateSchedule(activeFrom, activeUntil, data.ExpiresAt, data.FallbackURL)...)
+
+	rules, ruleErrors := normalizeRules(data.Rules)
+	fieldErrors = append(fieldErrors, ruleErrors...)
+	timeZone, err := normalizeTimeZone(data.TimeZone)
+	if err != nil {
+		fieldErrors = append(fieldErrors, FieldError{Field: "timeZone", Message: err.Error()})
+	}
+	variants, variantErrors := normalizeVariants(data.Variants)
+	fieldErrors = append(fieldErrors, variantErrors...)
+
+	if err := validateQueryPassthrough(data.QueryPassthrough); err != nil {
+		fieldErrors = append(fieldErrors, FieldError{Field: "queryPassthrough", Message: err.Error()})
+	}
+
+	if err := validateRedirectStatus(data.RedirectStatus); err != nil {
+		fieldErrors = append(fieldErrors, FieldError{Field: "redirectStatus", Message: err.Error()})
+	}
+
+	// Истёкшие ссылки импортируются, пока не прошёл период хранения
+	keyTTL := retentionTTL(data.ExpiresAt)
+	if data.ExpiresAt != nil && keyTTL <= 0 {
+		fieldErrors = append(fieldErrors, FieldError{Field: "expiresAt", Message: "link expired too long ago to be imported"})
+	}
+
+	if len(fieldErrors) > 0 {
+		return fieldErrors
+	}
+
+	createdAt := time.Now().UTC().Truncate(time.Millisecond)
+	if data.CreatedAt != nil {
+		createdAt = data.CreatedAt.UTC()
+	}
+	var expiresAt *time.Time
+	if data.ExpiresAt != nil {
+		t := data.ExpiresAt.UTC().Truncate(time.Second)
+		expiresAt = &t
+	}
+
+	row.KeyTTL = keyTTL
+	row.Link = &Link{
+		Scope: domain.Scope,
+		Code:  domain.Codes.Canonical(data.Code),
+		LinkRecord: LinkRecord{
+			URL:              destination,
+			Title:            title,
+			Owner:            data.Owner,
+			Tags:             tags,
+			State:            state,
+			CreatedAt:        createdAt,
+			ExpiresAt:        expiresAt,
+			PasswordHash:     data.PasswordHash,
+			MaxClicks:        data.MaxClicks,
+			ActiveFrom:       activeFrom,
+			ActiveUntil:      activeUntil,
+			FallbackURL:      fallbackURL,
+			Rules:            rules,
+			TimeZone:         timeZone,
+			Variants:         variants,
+			QueryPassthrough: data.QueryPassthrough,
+			PathPassthrough:  data.PathPassthrough,
+			RedirectStatus:   data.RedirectStatus,
+		},
+	}
+	return nil
+}
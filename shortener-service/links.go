@@ -0,0 +1,426 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/itcaat/url-shortener-demo/pkg/linkstore"
+)
+
+var errLinkNotFound = linkstore.ErrNotFound
+
+const (
+	statusActive    = "active"
+	statusExpired   = "expired"
+	statusDisabled  = "disabled"
+	statusExhausted = "exhausted"
+	statusScheduled = "scheduled"
+	statusEnded     = "ended"
+)
+
+// Link - запись ссылки вместе с её адресом (доменом и кодом)
+type Link struct {
+	Scope string
+	Code  string
+	LinkRecord
+}
+
+// Status возвращает состояние ссылки: отключённая, истёкшая, вне окна активности или активная
+func (l *Link) Status(now time.Time) string {
+	switch {
+	case l.State == stateDisabled:
+		return statusDisabled
+	case l.ExpiresAt != nil && !now.Before(*l.ExpiresAt):
+		return statusExpired
+	case l.ActiveUntil != nil && !now.Before(*l.ActiveUntil):
+		return statusEnded
+	case l.ActiveFrom != nil && now.Before(*l.ActiveFrom):
+		return statusScheduled
+	default:
+		return statusActive
+	}
+}
+
+type LinkResponse struct {
+	ShortCode string     `json:"shortCode"`
+	ShortURL  string     `json:"shortUrl"`
+	Original  string     `json:"originalUrl"`
+	Domain    string     `json:"domain"`
+	Title     string     `json:"title,omitempty"`
+	Owner     string     `json:"owner,omitempty"`
+	Tags      []string   `json:"tags"`
+	Status    string     `json:"status"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Protected bool       `json:"protected"`
+	MaxClicks int64      `json:"maxClicks,omitempty"`
+	// Окно активности ссылки и запасной адрес до его начала
+	ActiveFrom       *time.Time    `json:"activeFrom,omitempty"`
+	ActiveUntil      *time.Time    `json:"activeUntil,omitempty"`
+	FallbackURL      string        `json:"fallbackUrl,omitempty"`
+	Rules            []LinkRule    `json:"rules,omitempty"`
+	TimeZone         string        `json:"timeZone,omitempty"`
+	Variants         []LinkVariant `json:"variants,omitempty"`
+	QueryPassthrough string        `json:"queryPassthrough,omitempty"`
+	PathPassthrough  bool          `json:"pathPassthrough,omitempty"`
+	// Метки кампании utm_* из адреса ссылки
+	UTM            *UTMParams `json:"utm,omitempty"`
+	RedirectStatus int        `json:"redirectStatus,omitempty"`
+	// Сколько переходов осталось; заполняется только для одной ссылки, не в списке
+	ClicksLeft *int64 `json:"clicksLeft,omitempty"`
+}
+
+// UpdateLinkRequest - частичное обновление: изменяются только переданные поля
+type UpdateLinkRequest struct {
+	URL        *string    `json:"url,omitempty"`
+	Title      *string    `json:"title,omitempty"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	TTLSeconds int64      `json:"ttlSeconds,omitempty"`
+	NoExpiry   bool       `json:"noExpiry,omitempty"`
+	Tags       *[]string  `json:"tags,omitempty"`
+	Disabled   *bool      `json:"disabled,omitempty"`
+	// Новый пароль; пустая строка снимает защиту
+	Password *string `json:"password,omitempty"`
+	// Новый лимит переходов; 0 снимает ограничение. Уже засчитанные переходы не сбрасываются
+	MaxClicks *int64 `json:"maxClicks,omitempty"`
+	// Новые границы окна активности; прошедший activeUntil завершает кампанию сразу
+	ActiveFrom  *time.Time `json:"activeFrom,omitempty"`
+	ActiveUntil *time.Time `json:"activeUntil,omitempty"`
+	// Новый запасной адрес до начала окна; пустая строка убирает его
+	FallbackURL *string `json:"fallbackUrl,omitempty"`
+	// Снимает окно активности вместе с запасным адресом
+	NoSchedule bool `json:"noSchedule,omitempty"`
+	// Новый список правил маршрутизации целиком; пустой список удаляет правила
+	Rules *[]LinkRule `json:"rules,omitempty"`
+	// Новый часовой пояс расписаний; пустая строка - UTC
+	TimeZone *string `json:"timeZone,omitempty"`
+	// Новый набор вариантов A/B-теста целиком; пустой список завершает тест.
+	// Посетители с cookie остаются в своём варианте, пока вариант с тем же именем есть в ссылке.
+	Variants *[]LinkVariant `json:"variants,omitempty"`
+	// Новая политика передачи параметров запроса; пустая строка отключает передачу
+	QueryPassthrough *string `json:"queryPassthrough,omitempty"`
+	PathPassthrough  *bool   `json:"pathPassthrough,omitempty"`
+	// Метки кампании и пресет: записываются во все адреса перехода ссылки после остальных изменений,
+	// заменяя одноимённые параметры utm_*
+	UTM       *UTMParams `json:"utm,omitempty"`
+	UTMPreset string     `json:"utmPreset,omitempty"`
+	// Новый код перенаправления; 0 возвращает код по умолчанию. Браузеры, уже сохранившие
+	// постоянное перенаправление, увидят изменение только после истечения его кеша
+	RedirectStatus *int `json:"redirectStatus,omitempty"`
+}
+
+// loadLink читает ссылку из хранилища
+func loadLink(scope, code string) (*Link, error) {
+	record, err := store.Get(ctx, linkID(scope, code))
+	if err != nil {
+		return nil, err
+	}
+	return &Link{Scope: scope, Code: code, LinkRecord: *record}, nil
+}
+
+// saveLink перезаписывает существующую ссылку; индексы хранилище обновляет само
+func saveLink(link *Link) error {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	link.UpdatedAt = &now
+
+	return store.Update(ctx, linkID(link.Scope, link.Code), &link.LinkRecord, retentionTTL(link.ExpiresAt))
+}
+
+// deleteLink удаляет ссылку вместе с записью в индексе дедупликации
+func deleteLink(link *Link) error {
+	if err := store.Delete(ctx, linkID(link.Scope, link.Code)); err != nil {
+		return err
+	}
+	return forgetDuplicate(link.Scope, link.Owner, link.URL, link.Code)
+}
+
+func newLinkResponse(domain Domain, link *Link) LinkResponse {
+	response := LinkResponse{
+		ShortCode:        link.Code,
+		ShortURL:         domain.ShortURL(link.Code),
+		Original:         link.URL,
+		Domain:           domain.Name,
+		Title:            link.Title,
+		Owner:            link.Owner,
+		Tags:             link.Tags,
+		Status:           link.Status(time.Now()),
+		UpdatedAt:        link.UpdatedAt,
+		ExpiresAt:        link.ExpiresAt,
+		Protected:        link.PasswordHash != "",
+		MaxClicks:        link.MaxClicks,
+		ActiveFrom:       link.ActiveFrom,
+		ActiveUntil:      link.ActiveUntil,
+		FallbackURL:      link.FallbackURL,
+		Rules:            link.Rules,
+		TimeZone:         link.TimeZone,
+		Variants:         link.Variants,
+		QueryPassthrough: link.QueryPassthrough,
+		PathPassthrough:  link.PathPassthrough,
+		UTM:              linkUTM(link.URL),
+		RedirectStatus:   link.RedirectStatus,
+	}
+	if !link.CreatedAt.IsZero() {
+		response.CreatedAt = &link.CreatedAt
+	}
+	if response.Tags == nil {
+		response.Tags = []string{}
+	}
+	return response
+}
+
+// lookupLink находит ссылку по коду из пути и домену из ?domain= и отвечает ошибкой, если не нашла
+func lookupLink(w http.ResponseWriter, r *http.Request) (Domain, *Link, bool) {
+	domain, ok := resolveDomain(r.URL.Query().Get("domain"))
+	if !ok {
+		respondError(w, http.StatusBadRequest, "Unknown domain")
+		return Domain{}, nil, false
+	}
+
+	code := domain.Codes.Canonical(mux.Vars(r)["code"])
+	link, err := loadLink(domain.Scope, code)
+	if err == errLinkNotFound {
+		respondError(w, http.StatusNotFound, "Link not found")
+		return Domain{}, nil, false
+	} else if err != nil {
+		log.Printf("[Shortener Service] Store error: %v\n", err)
+		respondError(w, http.StatusInternalServerError, "Database error")
+		return Domain{}, nil, false
+	}
+
+	return domain, link, true
+}
+
+// detailedLinkResponse дополняет ответ остатком переходов ссылки с лимитом
+func detailedLinkResponse(domain Domain, link *Link) LinkResponse {
+	response := newLinkResponse(domain, link)
+	if link.MaxClicks <= 0 {
+		return response
+	}
+	used, err := store.Redemptions(ctx, linkID(link.Scope, link.Code))
+	if err != nil {
+		log.Printf("[Shortener Service] Failed to read click counter: %v\n", err)
+		return response
+	}
+	left := max(link.MaxClicks-used, 0)
+	response.ClicksLeft = &left
+	if left == 0 && response.Status == statusActive {
+		response.Status = statusExhausted
+	}
+	return response
+}
+
+func getLinkHandler(w http.ResponseWriter, r *http.Request) {
+	domain, link, ok := lookupLink(w, r)
+	if !ok {
+		return
+	}
+	respondJSON(w, http.StatusOK, detailedLinkResponse(domain, link))
+}
+
+func updateLinkHandler(w http.ResponseWriter, r *http.Request) {
+	domain, link, ok := lookupLink(w, r)
+	if !ok {
+		return
+	}
+	originalURL := link.URL
+
+	var req UpdateLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	var fieldErrors []FieldError
+
+	if req.URL != nil {
+		destination, err := normalizeURL(*req.URL)
+		if err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: "url", Message: err.Error()})
+		}
+		link.URL = destination
+	}
+
+	if req.Title != nil {
+		title, err := normalizeTitle(*req.Title)
+		if err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: "title", Message: err.Error()})
+		}
+		link.Title = title
+	}
+
+	switch {
+	case req.NoExpiry && (req.ExpiresAt != nil || req.TTLSeconds != 0):
+		fieldErrors = append(fieldErrors, FieldError{Field: "noExpiry", Message: "noExpiry cannot be combined with expiresAt or ttlSeconds"})
+	case req.NoExpiry:
+		link.ExpiresAt = nil
+	case req.ExpiresAt != nil || req.TTLSeconds != 0:
+		expiresAt, err := resolveExpiry(req.ExpiresAt, req.TTLSeconds)
+		if err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: expiryField(req.ExpiresAt), Message: err.Error()})
+		}
+		link.ExpiresAt = expiresAt
+	}
+
+	if req.Tags != nil {
+		tags, err := normalizeTags(*req.Tags)
+		if err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: "tags", Message: err.Error()})
+		}
+		link.Tags = tags
+	}
+
+	if req.Disabled != nil {
+		link.State = ""
+		if *req.Disabled {
+			link.State = stateDisabled
+		}
+	}
+
+	if req.MaxClicks != nil {
+		if err := validateMaxClicks(*req.MaxClicks); err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: "maxClicks", Message: err.Error()})
+		}
+		link.MaxClicks = *req.MaxClicks
+	}
+
+	switch {
+	case req.NoSchedule && (req.ActiveFrom != nil || req.ActiveUntil != nil || req.FallbackURL != nil):
+		fieldErrors = append(fieldErrors, FieldError{Field: "noSchedule", Message: "noSchedule cannot be combined with activeFrom, activeUntil or fallbackUrl"})
+	case req.NoSchedule:
+		link.ActiveFrom, link.ActiveUntil, link.FallbackURL = nil, nil, ""
+	default:
+		if req.ActiveFrom != nil {
+			link.ActiveFrom = scheduleTime(req.ActiveFrom)
+		}
+		if req.ActiveUntil != nil {
+			link.ActiveUntil = scheduleTime(req.ActiveUntil)
+		}
+		if req.FallbackURL != nil {
+			link.FallbackURL = ""
+			if *req.FallbackURL != "" {
+				fallbackURL, err := normalizeURL(*req.FallbackURL)
+				if err != nil {
+					fieldErrors = append(fieldErrors, FieldError{Field: "fallbackUrl", Message: err.Error()})
+				}
+				link.FallbackURL = fallbackURL
+			}
+		}
+	}
+	fieldErrors = append(fieldErrors, validateSchedule(link.ActiveFrom, link.ActiveUntil, link.ExpiresAt, link.FallbackURL)...)
+
+	if req.Rules != nil {
+		rules, ruleErrors := normalizeRules(*req.Rules)
+		fieldErrors = append(fieldErrors, ruleErrors...)
+		link.Rules = rules
+	}
+
+	if req.TimeZone != nil {
+		timeZone, err := normalizeTimeZone(*req.TimeZone)
+		if err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: "timeZone", Message: err.Error()})
+		}
+		link.TimeZone = timeZone
+	}
+
+	if req.Variants != nil {
+		variants, variantErrors := normalizeVariants(*req.Variants)
+		fieldErrors = append(fieldErrors, variantErrors...)
+		link.Variants = variants
+	}
+
+	if req.QueryPassthrough != nil {
+		if err := validateQueryPassthrough(*req.QueryPassthrough); err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: "queryPassthrough", Message: err.Error()})
+		}
+		link.QueryPassthrough = *req.QueryPassthrough
+	}
+	if req.PathPassthrough != nil {
+		link.PathPassthrough = *req.PathPassthrough
+	}
+
+	if req.RedirectStatus != nil {
+		if err := validateRedirectStatus(*req.RedirectStatus); err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: "redirectStatus", Message: err.Error()})
+		}
+		link.RedirectStatus = *req.RedirectStatus
+	}
+
+	if req.UTM != nil || req.UTMPreset != "" {
+		utm, utmErrors := resolveUTM(req.UTM, req.UTMPreset)
+		fieldErrors = append(fieldErrors, utmErrors...)
+		if len(fieldErrors) == 0 {
+			fieldErrors = applyUTM(&link.LinkRecord, utm)
+		}
+	}
+
+	if req.Password != nil && *req.Password != "" {
+		if err := validatePassword(*req.Password); err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: "password", Message: err.Error()})
+		}
+	}
+
+	if len(fieldErrors) > 0 {
+		respondValidationError(w, fieldErrors)
+		return
+	}
+
+	if req.Password != nil {
+		link.PasswordHash = ""
+		if *req.Password != "" {
+			hash, err := linkstore.HashPassword(*req.Password)
+			if err != nil {
+				log.Printf("[Shortener Service] Failed to hash password: %v\n", err)
+				respondError(w, http.StatusInternalServerError, "Failed to update link")
+				return
+			}
+			link.PasswordHash = hash
+		}
+	}
+
+	if err := saveLink(link); err == errLinkNotFound {
+		respondError(w, http.StatusNotFound, "Link not found")
+		return
+	} else if err != nil {
+		log.Printf("[Shortener Service] Failed to update link: %v\n", err)
+		respondError(w, http.StatusInternalServerError, "Failed to update link")
+		return
+	}
+
+	// Ссылка с новым адресом или переставшая подходить под дедупликацию не должна выдаваться повторно
+	if link.URL != originalURL || !dedupable(&link.LinkRecord) {
+		if err := forgetDuplicate(link.Scope, link.Owner, originalURL, link.Code); err != nil {
+			log.Printf("[Shortener Service] Failed to update dedupe index: %v\n", err)
+		}
+	}
+
+	log.Printf("[Shortener Service] Updated short code '%s'\n", linkID(link.Scope, link.Code))
+	respondJSON(w, http.StatusOK, detailedLinkResponse(domain, link))
+}
+
+func deleteLinkHandler(w http.ResponseWriter, r *http.Request) {
+	_, link, ok := lookupLink(w, r)
+	if !ok {
+		return
+	}
+
+	if err := deleteLink(link); err != nil {
+		log.Printf("[Shortener Service] Failed to delete link: %v\n", err)
+		respondError(w, http.StatusInternalServerError, "Failed to delete link")
+		return
+	}
+
+	log.Printf("[Shortener Service] Deleted short code '%s'\n", linkID(link.Scope, link.Code))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// expiryField возвращает имя поля для ошибки срока действия
+func expiryField(expiresAt *time.Time) string {
+	if expiresAt == nil {
+		return "ttlSeconds"
+	}
+	return "expiresAt"
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itcaat/url-shortener-demo/pkg/linkstore"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+
+	// Ограничение на число просмотренных элементов индекса за один запрос при фильтрации
+	maxScannedPerPage = 2000
+)
+
+type ListLinksResponse struct {
+	Links      []LinkResponse `json:"links"`
+	Count      int            `json:"count"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
+
+// listQuery - параметры выборки ссылок
+type listQuery struct {
+	Limit     int
+	Cursor    string // элемент индекса, после которого продолжается выборка
+	Ascending bool
+	Tag       string
+	Owner     string
+	Host      string
+	Status    string
+	Scope     *string
+}
+
+func parseListQuery(r *http.Request) (listQuery, []FieldError) {
+	params := r.URL.Query()
+	q := listQuery{
+		Limit:  defaultPageSize,
+		Tag:    strings.ToLower(strings.TrimSpace(params.Get("tag"))),
+		Owner:  params.Get("owner"),
+		Host:   strings.ToLower(strings.TrimSpace(params.Get("host"))),
+		Status: params.Get("status"),
+	}
+	var fieldErrors []FieldError
+
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxPageSize {
+			fieldErrors = append(fieldErrors, FieldError{Field: "limit", Message: fmt.Sprintf("limit must be between 1 and %d", maxPageSize)})
+		}
+		q.Limit = n
+	}
+
+	if cursor := params.Get("cursor"); cursor != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil || !strings.Contains(string(decoded), "|") {
+			fieldErrors = append(fieldErrors, FieldError{Field: "cursor", Message: "cursor is invalid"})
+		}
+		q.Cursor = string(decoded)
+	}
+
+	switch order := params.Get("order"); order {
+	case "", "desc":
+	case "asc":
+		q.Ascending = true
+	default:
+		fieldErrors = append(fieldErrors, FieldError{Field: "order", Message: "order must be 'asc' or 'desc'"})
+	}
+
+	switch q.Status {
+	case "", statusActive, statusExpired, statusDisabled, statusScheduled, statusEnded:
+	default:
+		fieldErrors = append(fieldErrors, FieldError{Field: "status", Message: "status must be one of active, expired, disabled, scheduled, ended"})
+	}
+
+	if params.Has("domain") {
+		domain, ok := resolveDomain(params.Get("domain"))
+		if !ok {
+			fieldErrors = append(fieldErrors, FieldError{Field: "domain", Message: fmt.Sprintf("domain '%s' is not configured", params.Get("domain"))})
+		}
+		q.Scope = &domain.Scope
+	}
+
+	return q, fieldErrors
+}
+
+// index выбирает самый узкий индекс для запроса; остальные фильтры применяются к загруженным ссылкам
+func (q listQuery) index() string {
+	switch {
+	case q.Tag != "":
+		return linkstore.TagIndex(q.Tag)
+	case q.Owner != "":
+		return linkstore.OwnerIndex(q.Owner)
+	default:
+		return linkstore.CreatedIndex
+	}
+}
+
+func (q listQuery) matches(link *Link, now time.Time) bool {
+	if q.Scope != nil && link.Scope != *q.Scope {
+		return false
+	}
+	if q.Owner != "" && link.Owner != q.Owner {
+		return false
+	}
+	if q.Tag != "" && !contains(link.Tags, q.Tag) {
+		return false
+	}
+	if q.Status != "" && link.Status(now) != q.Status {
+		return false
+	}
+	if q.Host != "" {
+		u, err := url.Parse(link.URL)
+		if err != nil || !strings.Contains(strings.ToLower(u.Hostname()), q.Host) {
+			return false
+		}
+	}
+	return true
+}
+
+// listLinks проходит по индексу пачками, начиная с курсора, и возвращает страницу ссылок
+// вместе с курсором следующей страницы (пустым, если индекс исчерпан)
+func listLinks(q listQuery) ([]*Link, string, error) {
+	index := q.index()
+	batchSize := q.Limit * 2
+	if batchSize < 50 {
+		batchSize = 50
+	}
+
+	var links []*Link
+	cursor := q.Cursor
+	scanned := 0
+	now := time.Now()
+
+	for {
+		members, err := store.Range(ctx, index, cursor, q.Ascending, batchSize)
+		if err != nil {
+			return nil, "", err
+		}
+
+		loaded, unreadable, err := loadIndexedLinks(members)
+		if err != nil {
+			return nil, "", err
+		}
+
+		var stale []string
+		for i, member := range members {
+			cursor = member
+			scanned++
+
+			link := loaded[i]
+			if link == nil {
+				// Ссылка удалена по истечении срока хранения - чистим индекс лениво.
+				// Нечитаемую запись только пропускаем: она не удалена, и её нельзя терять из индекса.
+				if !unreadable[i] {
+					stale = append(stale, member)
+				}
+				continue
+			}
+			if !q.matches(link, now) {
+				continue
+			}
+
+			links = append(links, link)
+			if len(links) == q.Limit {
+				removeStale(index, stale)
+				return links, cursor, nil
+			}
+		}
+		removeStale(index, stale)
+
+		if len(members) < batchSize {
+			return links, "", nil
+		}
+		if scanned >= maxScannedPerPage {
+			return links, cursor, nil
+		}
+	}
+}
+
+// loadIndexedLinks загружает ссылки по элементам индекса одним запросом; отсутствующие и нечитаемые - nil,
+// нечитаемые дополнительно отмечены в unreadable
+func loadIndexedLinks(members []string) (links []*Link, unreadable []bool, err error) {
+	if len(members) == 0 {
+		return nil, nil, nil
+	}
+
+	ids := make([]string, len(members))
+	for i, member := range members {
+		ids[i] = linkstore.MemberID(member)
+	}
+	records, err := store.GetMany(ctx, ids)
+	unreadable = make([]bool, len(members))
+	var unreadableErr *linkstore.UnreadableError
+	if errors.As(err, &unreadableErr) {
+		log.Printf("[Shortener Service] Skipping unreadable links in listing: %v\n", err)
+		broken := make(map[string]bool, len(unreadableErr.IDs))
+		for _, id := range unreadableErr.IDs {
+			broken[id] = true
+		}
+		for i, id := range ids {
+			unreadable[i] = broken[id]
+		}
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	links = make([]*Link, len(members))
+	for i, record := range records {
+		if record != nil {
+			scope, code := splitLinkID(ids[i])
+			links[i] = &Link{Scope: scope, Code: code, LinkRecord: *record}
+		}
+	}
+	return links, unreadable, nil
+}
+
+func removeStale(index string, members []string) {
+	if len(members) == 0 {
+		return
+	}
+	if err := store.Unindex(ctx, index, members...); err != nil {
+		log.Printf("[Shortener Service] Failed to clean up index %s: %v\n", index, err)
+	}
+}
+
+func listLinksHandler(w http.ResponseWriter, r *http.Request) {
+	q, fieldErrors := parseListQuery(r)
+	if len(fieldErrors) > 0 {
+		respondValidationError(w, fieldErrors)
+		return
+	}
+
+	links, next, err := listLinks(q)
+	if err != nil {
+		log.Printf("[Shortener Service] Failed to list links: %v\n", err)
+		respondError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	response := ListLinksResponse{
+		Links: make([]LinkResponse, 0, len(links)),
+		Count: len(links),
+	}
+	for _, link := range links {
+		response.Links = append(response.Links, newLinkResponse(domainForScope(link.Scope), link))
+	}
+	if next != "" {
+		response.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(next))
+	}
+
+	respondJSON(w, http.StatusOK, response)
+}
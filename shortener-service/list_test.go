@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/itcaat/url-shortener-demo/pkg/linkstore"
+)
+
+// damagedStore отдаёт выбранные ссылки как отсутствующие (missing) или нечитаемые (broken),
+// оставляя их элементы в индексах
+type damagedStore struct {
+	linkstore.Store
+	missing, broken map[string]bool
+}
+
+func (s damagedStore) GetMany(ctx context.Context, ids []string) ([]*linkstore.Record, error) {
+	records, err := s.Store.GetMany(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	var unreadable *linkstore.UnreadableError
+	for i, id := range ids {
+		switch {
+		case s.missing[id]:
+			records[i] = nil
+		case s.broken[id]:
+			records[i] = nil
+			if unreadable == nil {
+				unreadable = &linkstore.UnreadableError{Err: errors.New("unexpected end of JSON input")}
+			}
+			unreadable.IDs = append(unreadable.IDs, id)
+		}
+	}
+	if unreadable != nil {
+		return records, unreadable
+	}
+	return records, nil
+}
+
+func TestListLinksKeepsUnreadableLinksIndexed(t *testing.T) {
+	useMemoryStore(t)
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	var members []string
+	for i, code := range []string{"gone", "bad", "good"} {
+		record := &LinkRecord{URL: "https://example.com/" + code, Owner: "team", CreatedAt: base.Add(time.Duration(i) * time.Second)}
+		if _, err := store.Create(ctx, code, record, 0); err != nil {
+			t.Fatal(err)
+		}
+		members = append(members, linkstore.IndexMember(code, record.CreatedAt))
+	}
+	memory := store
+	store = damagedStore{Store: memory, missing: map[string]bool{"gone": true}, broken: map[string]bool{"bad": true}}
+
+	links, next, err := listLinks(listQuery{Limit: 10, Ascending: true, Owner: "team"})
+	if err != nil {
+		t.Fatalf("listLinks: %v", err)
+	}
+	if next != "" || len(links) != 1 || links[0].Code != "good" {
+		t.Fatalf("listLinks = %+v, %q; want only good", links, next)
+	}
+
+	// Из индекса убирается только отсутствующая ссылка
+	indexed, _ := memory.Range(ctx, linkstore.OwnerIndex("team"), "", true, 10)
+	if want := members[1:]; !reflect.DeepEqual(indexed, want) {
+		t.Errorf("owner index = %v, want %v", indexed, want)
+	}
+}
@@ -2,42 +2,92 @@ package main
 
 import (
 	"context"
-	"crypto/rand"
 	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
 	"log"
-	"math/big"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
-	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/mux"
+	"github.com/itcaat/url-shortener-demo/pkg/linkstore"
 	"github.com/itcaat/url-shortener-demo/pkg/tracing"
 	"github.com/rs/cors"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
 )
 
 var (
-	redisClient *redis.Client
-	ctx         = context.Background()
-	port        = getEnv("PORT", "3001")
-)
+	store linkstore.Store
+	ctx   = context.Background()
+	port  = getEnv("PORT", "3001")
 
-const (
-	charset    = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	codeLength = 6
+	// Сколько хранить истёкшие ссылки, чтобы redirect-service отвечал 410 вместо 404
+	expiredRetention = getEnvDuration("EXPIRED_LINK_RETENTION", 30*24*time.Hour)
 )
 
 type ShortenRequest struct {
-	URL string `json:"url"`
+	URL        string     `json:"url"`
+	Alias      string     `json:"alias,omitempty"`
+	Title      string     `json:"title,omitempty"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	TTLSeconds int64      `json:"ttlSeconds,omitempty"`
+	Owner      string     `json:"owner,omitempty"`
+	Domain     string     `json:"domain,omitempty"`
+	Tags       []string   `json:"tags,omitempty"`
+	// Пароль, который redirect-service спросит перед переходом; хранится только хеш
+	Password string `json:"password,omitempty"`
+	// Сколько переходов разрешено (1 - одноразовая ссылка); 0 - без ограничения
+	MaxClicks int64 `json:"maxClicks,omitempty"`
+	// Окно, в котором ссылка открывается; до activeFrom она ведёт на fallbackUrl, если он задан
+	ActiveFrom  *time.Time `json:"activeFrom,omitempty"`
+	ActiveUntil *time.Time `json:"activeUntil,omitempty"`
+	FallbackURL string     `json:"fallbackUrl,omitempty"`
+	// Правила выбора адреса по устройству и стране посетителя и по расписанию; url - адрес по умолчанию
+	Rules []LinkRule `json:"rules,omitempty"`
+	// Часовой пояс IANA для расписаний правил (по умолчанию UTC)
+	TimeZone string `json:"timeZone,omitempty"`
+	// Адреса A/B-теста с весами: трафик без подходящего правила делится между ними вместо url
+	Variants []LinkVariant `json:"variants,omitempty"`
+	// Передача параметров запроса (merge - параметры адреса важнее, override - важнее параметры запроса)
+	// и пути после кода в адрес перехода
+	QueryPassthrough string `json:"queryPassthrough,omitempty"`
+	PathPassthrough  bool   `json:"pathPassthrough,omitempty"`
+	// Метки кампании, которые добавляются в адреса перехода параметрами utm_*; поля utm дополняют
+	// и переопределяют сохранённый пресет utmPreset
+	UTM       *UTMParams `json:"utm,omitempty"`
+	UTMPreset string     `json:"utmPreset,omitempty"`
+	// Код перенаправления: 301/308 - постоянное (кешируется браузерами), 302/307 - временное;
+	// 307 и 308 сохраняют метод запроса. 0 - DEFAULT_REDIRECT_STATUS redirect-service
+	RedirectStatus int `json:"redirectStatus,omitempty"`
 }
 
 type ShortenResponse struct {
-	ShortCode string `json:"shortCode"`
-	ShortURL  string `json:"shortUrl"`
-	Original  string `json:"originalUrl"`
+	ShortCode string     `json:"shortCode"`
+	ShortURL  string     `json:"shortUrl"`
+	Original  string     `json:"originalUrl"`
+	Domain    string     `json:"domain"`
+	Title     string     `json:"title,omitempty"`
+	Tags      []string   `json:"tags,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Protected bool       `json:"protected,omitempty"`
+	MaxClicks int64      `json:"maxClicks,omitempty"`
+	// Окно активности ссылки и запасной адрес до его начала
+	ActiveFrom       *time.Time    `json:"activeFrom,omitempty"`
+	ActiveUntil      *time.Time    `json:"activeUntil,omitempty"`
+	FallbackURL      string        `json:"fallbackUrl,omitempty"`
+	Rules            []LinkRule    `json:"rules,omitempty"`
+	TimeZone         string        `json:"timeZone,omitempty"`
+	Variants         []LinkVariant `json:"variants,omitempty"`
+	QueryPassthrough string        `json:"queryPassthrough,omitempty"`
+	PathPassthrough  bool          `json:"pathPassthrough,omitempty"`
+	UTM              *UTMParams    `json:"utm,omitempty"`
+	RedirectStatus   int           `json:"redirectStatus,omitempty"`
+	Reused           bool          `json:"reused"`
 }
 
 type HealthResponse struct {
@@ -47,6 +97,21 @@ type HealthResponse struct {
 }
 
 func main() {
+	// Подкоманды обслуживания данных: migrate, import, export
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			runMigration(os.Args[2:])
+			return
+		case "import":
+			runImport(os.Args[2:])
+			return
+		case "export":
+			runExport(os.Args[2:])
+			return
+		}
+	}
+
 	// Initialize tracing (опционально, только если JAEGER_AGENT_HOST задан)
 	if jaegerHost := os.Getenv("JAEGER_AGENT_HOST"); jaegerHost != "" {
 		log.Println("[Shortener Service] Initializing distributed tracing...")
@@ -61,21 +126,13 @@ func main() {
 		log.Println("[Shortener Service] ℹ️  Distributed tracing disabled (JAEGER_AGENT_HOST not set)")
 	}
 
-	initRedis()
-
-	router := mux.NewRouter()
-
-	// Add OpenTelemetry middleware
-	router.Use(otelmux.Middleware("shortener-service"))
+	initDomains()
+	initStore()
+	defer store.Close()
+	initCodeGenerator()
+	initCodeFilter()
 
-	router.HandleFunc("/health", healthHandler).Methods("GET")
-	router.HandleFunc("/shorten", shortenHandler).Methods("POST")
-
-	handler := cors.New(cors.Options{
-		AllowedOrigins: []string{"*"},
-		AllowedMethods: []string{"GET", "POST", "OPTIONS"},
-		AllowedHeaders: []string{"*"},
-	}).Handler(router)
+	handler := newHandler()
 
 	// Graceful shutdown
 	server := &http.Server{
@@ -88,6 +145,8 @@ func main() {
 		log.Printf("[Shortener Service] Connected to redis at %s:%s\n",
 			getEnv("REDIS_HOST", "localhost"),
 			getEnv("REDIS_PORT", "6379"))
+		log.Printf("[Shortener Service] Public base URL: %s (branded domains: %d)\n",
+			defaultDomain.BaseURL, len(brandedDomains))
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatal(err)
 		}
@@ -108,26 +167,60 @@ func main() {
 	log.Println("[Shortener Service] Server exited")
 }
 
-func initRedis() {
-	redisHost := getEnv("REDIS_HOST", "localhost")
-	redisPort := getEnv("REDIS_PORT", "6379")
+// newHandler собирает маршруты сервиса с трассировкой и CORS
+func newHandler() http.Handler {
+	router := mux.NewRouter()
+
+	// Add OpenTelemetry middleware
+	router.Use(otelmux.Middleware("shortener-service"))
 
-	redisClient = redis.NewClient(&redis.Options{
-		Addr:     redisHost + ":" + redisPort,
-		Password: "",
-		DB:       0,
+	router.HandleFunc("/health", healthHandler).Methods("GET")
+	router.HandleFunc("/shorten", shortenHandler).Methods("POST")
+	router.HandleFunc("/shorten/batch", batchShortenHandler).Methods("POST")
+	router.HandleFunc("/links", listLinksHandler).Methods("GET")
+	router.HandleFunc("/links/import", importLinksHandler).Methods("POST")
+	router.HandleFunc("/links/export", exportLinksHandler).Methods("GET")
+	router.HandleFunc("/links/{code}", getLinkHandler).Methods("GET")
+	router.HandleFunc("/links/{code}", updateLinkHandler).Methods("PATCH")
+	router.HandleFunc("/links/{code}", deleteLinkHandler).Methods("DELETE")
+	router.HandleFunc("/links/{code}/route", routePreviewHandler).Methods("GET")
+	router.HandleFunc("/utm-presets", listUTMPresetsHandler).Methods("GET")
+	router.HandleFunc("/utm-presets/{name}", getUTMPresetHandler).Methods("GET")
+	router.HandleFunc("/utm-presets/{name}", putUTMPresetHandler).Methods("PUT")
+	router.HandleFunc("/utm-presets/{name}", deleteUTMPresetHandler).Methods("DELETE")
+	router.Handle("/debug/vars", expvar.Handler()).Methods("GET")
+
+	return cors.New(cors.Options{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders: []string{"*"},
+	}).Handler(router)
+}
+
+// initStore открывает хранилище ссылок, выбранное в LINK_STORE: redis (по умолчанию), memory или bolt
+func initStore() {
+	shared, _ := strconv.ParseBool(getEnv("LINK_STORE_SHARED", "false"))
+
+	var err error
+	store, err = linkstore.Open(linkstore.Config{
+		Backend:   getEnv("LINK_STORE", linkstore.BackendRedis),
+		RedisAddr: getEnv("REDIS_HOST", "localhost") + ":" + getEnv("REDIS_PORT", "6379"),
+		BoltPath:  getEnv("LINK_STORE_PATH", "links.db"),
+		Shared:    shared,
 	})
+	if err != nil {
+		log.Fatalf("Failed to open link store: %v", err)
+	}
 
 	// Проверка соединения
-	_, err := redisClient.Ping(ctx).Result()
-	if err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+	if err := store.Ping(ctx); err != nil {
+		log.Fatalf("Failed to connect to link store: %v", err)
 	}
 }
 
 func healthHandler(w http.ResponseWriter, r *http.Request) {
-	// Проверка Redis
-	_, err := redisClient.Ping(ctx).Result()
+	// Проверка хранилища
+	err := store.Ping(ctx)
 	status := "healthy"
 	if err != nil {
 		status = "unhealthy"
@@ -141,6 +234,175 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, response)
 }
 
+// shortenPlan - проверенный запрос на создание ссылки, готовый к записи в хранилище
+type shortenPlan struct {
+	domain Domain
+	alias  string
+	link   *Link
+	keyTTL time.Duration
+	dedupe bool
+}
+
+// planShorten валидирует запрос и готовит запись ссылки; ошибки валидации возвращаются списком по полям,
+// внутренние ошибки (например, хеширования пароля) - через error
+func planShorten(req ShortenRequest) (*shortenPlan, []FieldError, error) {
+	var fieldErrors []FieldError
+
+	destination, err := normalizeURL(req.URL)
+	if err != nil {
+		fieldErrors = append(fieldErrors, FieldError{Field: "url", Message: err.Error()})
+	}
+
+	if req.Alias != "" {
+		if err := validateAlias(req.Alias); err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: "alias", Message: err.Error()})
+		}
+	}
+
+	domain, ok := resolveDomain(req.Domain)
+	if !ok {
+		fieldErrors = append(fieldErrors, FieldError{Field: "domain", Message: fmt.Sprintf("domain '%s' is not configured", req.Domain)})
+	}
+
+	if len(req.Owner) > maxOwnerLength {
+		fieldErrors = append(fieldErrors, FieldError{Field: "owner", Message: fmt.Sprintf("owner must not exceed %d characters", maxOwnerLength)})
+	}
+
+	title, err := normalizeTitle(req.Title)
+	if err != nil {
+		fieldErrors = append(fieldErrors, FieldError{Field: "title", Message: err.Error()})
+	}
+
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		fieldErrors = append(fieldErrors, FieldError{Field: "tags", Message: err.Error()})
+	}
+
+	expiresAt, err := resolveExpiry(req.ExpiresAt, req.TTLSeconds)
+	if err != nil {
+		fieldErrors = append(fieldErrors, FieldError{Field: expiryField(req.ExpiresAt), Message: err.Error()})
+	}
+
+	if req.Password != "" {
+		if err := validatePassword(req.Password); err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: "password", Message: err.Error()})
+		}
+	}
+
+	if err := validateMaxClicks(req.MaxClicks); err != nil {
+		fieldErrors = append(fieldErrors, FieldError{Field: "maxClicks", Message: err.Error()})
+	}
+
+	activeFrom, activeUntil := scheduleTime(req.ActiveFrom), scheduleTime(req.ActiveUntil)
+	if activeUntil != nil && !activeUntil.After(time.Now()) {
+		fieldErrors = append(fieldErrors, FieldError{Field: "activeUntil", Message: "activeUntil must be in the future"})
+	}
+	var fallbackURL string
+	if req.FallbackURL != "" {
+		if fallbackURL, err = normalizeURL(req.FallbackURL); err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: "fallbackUrl", Message: err.Error()})
+		}
+	}
+	fieldErrors = append(fieldErrors, validateSchedule(activeFrom, activeUntil, expiresAt, req.FallbackURL)...)
+
+	rules, ruleErrors := normalizeRules(req.Rules)
+	fieldErrors = append(fieldErrors, ruleErrors...)
+	timeZone, err := normalizeTimeZone(req.TimeZone)
+	if err != nil {
+		fieldErrors = append(fieldErrors, FieldError{Field: "timeZone", Message: err.Error()})
+	}
+	variants, variantErrors := normalizeVariants(req.Variants)
+	fieldErrors = append(fieldErrors, variantErrors...)
+
+	if err := validateQueryPassthrough(req.QueryPassthrough); err != nil {
+		fieldErrors = append(fieldErrors, FieldError{Field: "queryPassthrough", Message: err.Error()})
+	}
+
+	utm, utmErrors := resolveUTM(req.UTM, req.UTMPreset)
+	fieldErrors = append(fieldErrors, utmErrors...)
+
+	if err := validateRedirectStatus(req.RedirectStatus); err != nil {
+		fieldErrors = append(fieldErrors, FieldError{Field: "redirectStatus", Message: err.Error()})
+	}
+
+	if len(fieldErrors) > 0 {
+		return nil, fieldErrors, nil
+	}
+
+	var passwordHash string
+	if req.Password != "" {
+		if passwordHash, err = linkstore.HashPassword(req.Password); err != nil {
+			return nil, nil, fmt.Errorf("hash password: %w", err)
+		}
+	}
+
+	record := LinkRecord{
+		URL:              destination,
+		Title:            title,
+		Owner:            req.Owner,
+		Tags:             tags,
+		CreatedAt:        time.Now().UTC().Truncate(time.Millisecond),
+		ExpiresAt:        expiresAt,
+		PasswordHash:     passwordHash,
+		MaxClicks:        req.MaxClicks,
+		ActiveFrom:       activeFrom,
+		ActiveUntil:      activeUntil,
+		FallbackURL:      fallbackURL,
+		Rules:            rules,
+		TimeZone:         timeZone,
+		Variants:         variants,
+		QueryPassthrough: req.QueryPassthrough,
+		PathPassthrough:  req.PathPassthrough,
+		RedirectStatus:   req.RedirectStatus,
+	}
+	if fieldErrors := applyUTM(&record, utm); len(fieldErrors) > 0 {
+		return nil, fieldErrors, nil
+	}
+
+	return &shortenPlan{
+		domain: domain,
+		alias:  domain.Codes.Canonical(req.Alias),
+		link:   &Link{Scope: domain.Scope, LinkRecord: record},
+		keyTTL: retentionTTL(expiresAt),
+		// Дедупликация применяется только к ссылкам без алиаса, подходящим под dedupable
+		dedupe: deduplicateURLs && req.Alias == "" && dedupable(&record),
+	}, nil, nil
+}
+
+// response строит ответ по созданной (или переиспользованной) ссылке
+func (p *shortenPlan) response(link *Link, reused bool) ShortenResponse {
+	return ShortenResponse{
+		ShortCode:        link.Code,
+		ShortURL:         p.domain.ShortURL(link.Code),
+		Original:         link.URL,
+		Domain:           p.domain.Name,
+		Title:            link.Title,
+		Tags:             link.Tags,
+		ExpiresAt:        link.ExpiresAt,
+		Protected:        link.PasswordHash != "",
+		MaxClicks:        link.MaxClicks,
+		ActiveFrom:       link.ActiveFrom,
+		ActiveUntil:      link.ActiveUntil,
+		FallbackURL:      link.FallbackURL,
+		Rules:            link.Rules,
+		TimeZone:         link.TimeZone,
+		Variants:         link.Variants,
+		QueryPassthrough: link.QueryPassthrough,
+		PathPassthrough:  link.PathPassthrough,
+		UTM:              linkUTM(link.URL),
+		RedirectStatus:   link.RedirectStatus,
+		Reused:           reused,
+	}
+}
+
+// finishShorten записывает код созданной ссылки в занятый ключ дедупликации
+func finishShorten(plan *shortenPlan, claim *dedupeClaim) {
+	link := plan.link
+	claim.complete(link.Code)
+
+	log.Printf("[Shortener Service] Created short code '%s' for URL: %s\n", linkID(link.Scope, link.Code), link.URL)
+}
+
 func shortenHandler(w http.ResponseWriter, r *http.Request) {
 	var req ShortenRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -148,65 +410,92 @@ func shortenHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.URL == "" {
-		respondError(w, http.StatusBadRequest, "URL is required")
+	plan, fieldErrors, err := planShorten(req)
+	if err != nil {
+		log.Printf("[Shortener Service] Failed to prepare link: %v\n", err)
+		respondError(w, http.StatusInternalServerError, "Failed to save URL")
 		return
 	}
-
-	// Генерация короткого кода
-	shortCode, err := generateShortCode()
-	if err != nil {
-		log.Printf("[Shortener Service] Error generating short code: %v\n", err)
-		respondError(w, http.StatusInternalServerError, "Failed to generate short code")
+	if len(fieldErrors) > 0 {
+		respondValidationError(w, fieldErrors)
 		return
 	}
+	link := plan.link
 
-	// Проверка уникальности
-	for {
-		exists, err := redisClient.Exists(ctx, "url:"+shortCode).Result()
+	// Ключ дедупликации занимается до создания ссылки: параллельный запрос с тем же URL получит эту же ссылку
+	var claim *dedupeClaim
+	if plan.dedupe {
+		existing, c, err := claimDuplicate(link.Scope, link.Owner, link.URL)
 		if err != nil {
-			log.Printf("[Shortener Service] Redis error: %v\n", err)
+			log.Printf("[Shortener Service] Store error: %v\n", err)
 			respondError(w, http.StatusInternalServerError, "Database error")
 			return
 		}
-		if exists == 0 {
-			break
+		if existing != nil {
+			log.Printf("[Shortener Service] Reusing short code '%s' for URL: %s\n", existing.Code, link.URL)
+			respondJSON(w, http.StatusOK, plan.response(existing, true))
+			return
 		}
-		shortCode, _ = generateShortCode()
+		claim = c
 	}
 
-	// Сохранение в Redis
-	err = redisClient.Set(ctx, "url:"+shortCode, req.URL, 0).Err()
-	if err != nil {
-		log.Printf("[Shortener Service] Failed to save to Redis: %v\n", err)
-		respondError(w, http.StatusInternalServerError, "Failed to save URL")
-		return
+	if plan.alias != "" {
+		// Атомарный захват алиаса: хранилище не даст двум запросам занять один код
+		link.Code = plan.alias
+		claimed, err := claimCode(link, plan.keyTTL)
+		if err != nil {
+			log.Printf("[Shortener Service] Failed to save link: %v\n", err)
+			respondError(w, http.StatusInternalServerError, "Failed to save URL")
+			return
+		}
+		if !claimed {
+			respondError(w, http.StatusConflict, "Alias is already taken")
+			return
+		}
+	} else {
+		if err := claimGeneratedCode(link, plan.keyTTL); err != nil {
+			claim.release()
+			log.Printf("[Shortener Service] Error generating short code: %v\n", err)
+			respondError(w, http.StatusInternalServerError, "Failed to generate short code")
+			return
+		}
 	}
 
-	log.Printf("[Shortener Service] Created short code '%s' for URL: %s\n", shortCode, req.URL)
+	finishShorten(plan, claim)
+	respondJSON(w, http.StatusCreated, plan.response(link, false))
+}
 
-	response := ShortenResponse{
-		ShortCode: shortCode,
-		ShortURL:  "http://localhost:3002/" + shortCode,
-		Original:  req.URL,
+// resolveExpiry вычисляет момент истечения ссылки из expiresAt или ttlSeconds
+func resolveExpiry(at *time.Time, ttlSeconds int64) (*time.Time, error) {
+	if at != nil && ttlSeconds != 0 {
+		return nil, errors.New("only one of expiresAt and ttlSeconds may be set")
 	}
 
-	respondJSON(w, http.StatusCreated, response)
-}
-
-func generateShortCode() (string, error) {
-	result := make([]byte, codeLength)
-	charsetLen := big.NewInt(int64(len(charset)))
+	var expiresAt time.Time
+	switch {
+	case at != nil:
+		expiresAt = at.UTC()
+	case ttlSeconds < 0:
+		return nil, errors.New("ttlSeconds must be positive")
+	case ttlSeconds > 0:
+		expiresAt = time.Now().UTC().Add(time.Duration(ttlSeconds) * time.Second)
+	default:
+		return nil, nil
+	}
 
-	for i := 0; i < codeLength; i++ {
-		num, err := rand.Int(rand.Reader, charsetLen)
-		if err != nil {
-			return "", err
-		}
-		result[i] = charset[num.Int64()]
+	if !expiresAt.After(time.Now()) {
+		return nil, errors.New("expiresAt must be in the future")
 	}
+	expiresAt = expiresAt.Truncate(time.Second)
+	return &expiresAt, nil
+}
 
-	return string(result), nil
+// retentionTTL возвращает срок хранения записи: ссылка живёт до истечения плюс период хранения
+func retentionTTL(expiresAt *time.Time) time.Duration {
+	if expiresAt == nil {
+		return 0
+	}
+	return time.Until(*expiresAt) + expiredRetention
 }
 
 func respondJSON(w http.ResponseWriter, status int, data interface{}) {
@@ -225,3 +514,29 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("[Shortener Service] Invalid integer in %s=%q, using %d\n", key, value, defaultValue)
+		return defaultValue
+	}
+	return n
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("[Shortener Service] Invalid duration in %s=%q, using %s\n", key, value, defaultValue)
+		return defaultValue
+	}
+	return d
+}
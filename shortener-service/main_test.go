@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testService готовит сервис к тестам обработчиков: хранилище в памяти, домены и фильтр кодов по умолчанию
+func testService(t *testing.T) {
+	t.Helper()
+	useMemoryStore(t)
+	initDomains()
+	initCodeFilter()
+}
+
+// serve передаёт запрос маршрутам сервиса
+func serve(method, target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	newHandler().ServeHTTP(w, r)
+	return w
+}
+
+// decodeBody разбирает JSON-ответ обработчика
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decode %q: %v", w.Body.String(), err)
+	}
+}
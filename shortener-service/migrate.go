@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/itcaat/url-shortener-demo/pkg/linkstore"
+)
+
+// runMigration переводит ссылки из строкового формата (url:<id> + expires:<id> + meta:<id>)
+// в версионированные записи и добавляет их во вторичные индексы.
+// Запуск: shortener-service migrate [-dry-run]
+func runMigration(args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "only report legacy links, do not change data")
+	flags.Parse(args)
+
+	initStore()
+	defer store.Close()
+
+	// Устаревший формат бывает только в Redis; остальные хранилища всегда пишут записи
+	migrator, ok := store.(linkstore.Migrator)
+	if !ok {
+		log.Println("[Shortener Service] Link store has no legacy data, nothing to migrate")
+		return
+	}
+
+	result, err := migrator.MigrateLegacy(ctx, *dryRun, func(id string, err error) {
+		log.Printf("[Shortener Service] Failed to migrate '%s': %v\n", id, err)
+	})
+	if err != nil {
+		log.Fatalf("[Shortener Service] Migration scan failed: %v", err)
+	}
+
+	action := "Migrated"
+	if *dryRun {
+		action = "Would migrate"
+	}
+	log.Printf("[Shortener Service] %s %d of %d links (%d failed)\n", action, result.Migrated, result.Scanned, result.Failed)
+}
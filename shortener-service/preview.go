@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/itcaat/url-shortener-demo/pkg/linkstore"
+)
+
+// RoutePreviewResponse - куда redirect-service отправил бы посетителя в заданный момент
+type RoutePreviewResponse struct {
+	ShortCode string    `json:"shortCode"`
+	At        time.Time `json:"at"`
+	// Тот же момент в часовом поясе ссылки, по которому проверяются расписания
+	LocalTime string `json:"localTime"`
+	TimeZone  string `json:"timeZone"`
+	Status    string `json:"status"`
+	// Ветка правил: имя правила, "default" или пусто, если у ссылки нет правил
+	Route       string `json:"route,omitempty"`
+	Destination string `json:"destination,omitempty"`
+	// Для ветки по умолчанию с A/B-тестом адрес выбирается среди вариантов для каждого посетителя
+	Variants []LinkVariant `json:"variants,omitempty"`
+	// Перед переходом redirect-service спросит пароль
+	Protected bool `json:"protected,omitempty"`
+}
+
+// routePreviewHandler - пробный выбор адреса без перехода и без записи в аналитику:
+// GET /links/{code}/route?at=2024-05-01T18:30:00Z&platform=ios&device=mobile&country=DE
+func routePreviewHandler(w http.ResponseWriter, r *http.Request) {
+	_, link, ok := lookupLink(w, r)
+	if !ok {
+		return
+	}
+
+	params := r.URL.Query()
+	var fieldErrors []FieldError
+
+	at := time.Now()
+	if value := params.Get("at"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: "at", Message: "at must be an RFC 3339 timestamp, e.g. 2024-05-01T18:30:00Z"})
+		}
+		at = parsed
+	}
+
+	visitor := linkstore.Visitor{Time: at}
+	if value := params.Get("platform"); value != "" {
+		platforms, err := normalizeConditions([]string{value}, linkstore.Platforms)
+		if err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: "platform", Message: err.Error()})
+		} else {
+			visitor.Platform = platforms[0]
+		}
+	}
+	if value := params.Get("device"); value != "" {
+		devices, err := normalizeConditions([]string{value}, linkstore.Devices)
+		if err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: "device", Message: err.Error()})
+		} else {
+			visitor.Device = devices[0]
+		}
+	}
+	if value := params.Get("country"); value != "" {
+		countries, err := normalizeCountries([]string{value})
+		if err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: "country", Message: err.Error()})
+		} else {
+			visitor.Country = countries[0]
+		}
+	}
+
+	if len(fieldErrors) > 0 {
+		respondValidationError(w, fieldErrors)
+		return
+	}
+
+	location := link.Location()
+	response := RoutePreviewResponse{
+		ShortCode: link.Code,
+		At:        at.UTC(),
+		LocalTime: at.In(location).Format(time.RFC3339),
+		TimeZone:  location.String(),
+		Status:    link.Status(at),
+		Protected: link.PasswordHash != "",
+	}
+
+	// Вне окна активности и после отключения или истечения правила не проверяются
+	switch response.Status {
+	case statusScheduled:
+		response.Destination = link.FallbackURL
+	case statusActive:
+		var matched bool
+		response.Destination, response.Route, matched = link.Route(visitor)
+		if !matched && len(link.Variants) > 0 {
+			response.Destination = ""
+			response.Variants = link.Variants
+		}
+	}
+
+	respondJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,8 @@
+package main
+
+import "github.com/itcaat/url-shortener-demo/pkg/linkstore"
+
+// LinkRecord - версионированная запись ссылки; формат хранения определяет pkg/linkstore
+type LinkRecord = linkstore.Record
+
+const stateDisabled = linkstore.StateDisabled
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/itcaat/url-shortener-demo/pkg/linkstore"
+)
+
+const (
+	maxRules          = 20
+	maxRuleNameLength = 32
+	maxTimeWindows    = 14
+
+	maxVariants      = 10
+	maxVariantWeight = 1000
+)
+
+// LinkRule - правило выбора адреса перехода по устройству и стране посетителя
+type LinkRule = linkstore.Rule
+
+// LinkVariant - взвешенный адрес A/B-теста
+type LinkVariant = linkstore.Variant
+
+// TimeWindow - окно недельного расписания правила
+type TimeWindow = linkstore.TimeWindow
+
+// normalizeRules проверяет правила маршрутизации и приводит условия к каноническому виду.
+// Правилу без имени даётся имя из условий ("ios", "android+tablet", "de"): под ним переход попадает в аналитику.
+func normalizeRules(rules []LinkRule) ([]LinkRule, []FieldError) {
+	if len(rules) > maxRules {
+		return nil, []FieldError{{Field: "rules", Message: fmt.Sprintf("no more than %d rules are allowed", maxRules)}}
+	}
+
+	var fieldErrors []FieldError
+	normalized := make([]LinkRule, 0, len(rules))
+	names := make(map[string]bool, len(rules))
+	for i, rule := range rules {
+		field := fmt.Sprintf("rules[%d]", i)
+
+		platforms, err := normalizeConditions(rule.Platforms, linkstore.Platforms)
+		if err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: field + ".platforms", Message: err.Error()})
+		}
+		devices, err := normalizeConditions(rule.Devices, linkstore.Devices)
+		if err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: field + ".devices", Message: err.Error()})
+		}
+		countries, err := normalizeCountries(rule.Countries)
+		if err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: field + ".countries", Message: err.Error()})
+		}
+		schedule, err := normalizeSchedule(rule.Schedule)
+		if err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: field + ".schedule", Message: err.Error()})
+		}
+		if len(rule.Platforms) == 0 && len(rule.Devices) == 0 && len(rule.Countries) == 0 && len(rule.Schedule) == 0 {
+			fieldErrors = append(fieldErrors, FieldError{Field: field, Message: "rule must have at least one condition"})
+		}
+
+		destination, err := normalizeURL(rule.URL)
+		if err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: field + ".url", Message: err.Error()})
+		}
+
+		name := strings.ToLower(strings.TrimSpace(rule.Name))
+		if name == "" {
+			conditions := append(platforms[:len(platforms):len(platforms)], devices...)
+			for _, country := range countries {
+				conditions = append(conditions, strings.ToLower(country))
+			}
+			if len(schedule) > 0 {
+				conditions = append(conditions, "schedule")
+			}
+			name = strings.Join(conditions, "+")
+		}
+		// Пустое имя - условия правила неверны, ошибка по ним уже добавлена
+		if name != "" {
+			if err := checkBranchName(name, names); err != nil {
+				fieldErrors = append(fieldErrors, FieldError{Field: field + ".name", Message: err.Error()})
+			}
+		}
+
+		normalized = append(normalized, LinkRule{
+			Name:      name,
+			Platforms: platforms,
+			Devices:   devices,
+			Countries: countries,
+			Schedule:  schedule,
+			URL:       destination,
+		})
+	}
+	if len(fieldErrors) > 0 {
+		return nil, fieldErrors
+	}
+	if len(normalized) == 0 {
+		return nil, nil
+	}
+	return normalized, nil
+}
+
+// normalizeVariants проверяет варианты A/B-теста. Вариантам без имени даются имена a, b, c...
+// по порядку; нулевой вес приостанавливает вариант, но хотя бы один вес должен быть положительным.
+func normalizeVariants(variants []LinkVariant) ([]LinkVariant, []FieldError) {
+	if len(variants) == 0 {
+		return nil, nil
+	}
+	if len(variants) < 2 || len(variants) > maxVariants {
+		return nil, []FieldError{{Field: "variants", Message: fmt.Sprintf("an A/B test needs between 2 and %d variants", maxVariants)}}
+	}
+
+	var fieldErrors []FieldError
+	normalized := make([]LinkVariant, 0, len(variants))
+	names := make(map[string]bool, len(variants))
+	totalWeight := 0
+	for i, variant := range variants {
+		field := fmt.Sprintf("variants[%d]", i)
+
+		destination, err := normalizeURL(variant.URL)
+		if err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: field + ".url", Message: err.Error()})
+		}
+
+		if variant.Weight < 0 || variant.Weight > maxVariantWeight {
+			fieldErrors = append(fieldErrors, FieldError{Field: field + ".weight", Message: fmt.Sprintf("weight must be between 0 and %d", maxVariantWeight)})
+		}
+		totalWeight += variant.Weight
+
+		name := strings.ToLower(strings.TrimSpace(variant.Name))
+		if name == "" {
+			name = string(rune('a' + i))
+		}
+		if err := checkBranchName(name, names); err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: field + ".name", Message: err.Error()})
+		}
+
+		normalized = append(normalized, LinkVariant{Name: name, URL: destination, Weight: variant.Weight})
+	}
+	if totalWeight <= 0 {
+		fieldErrors = append(fieldErrors, FieldError{Field: "variants", Message: "at least one variant must have a positive weight"})
+	}
+	if len(fieldErrors) > 0 {
+		return nil, fieldErrors
+	}
+	return normalized, nil
+}
+
+// checkBranchName проверяет имя правила или варианта, под которым переходы попадают в аналитику,
+// и запоминает его в names, чтобы имена внутри ссылки не повторялись
+func checkBranchName(name string, names map[string]bool) error {
+	if len(name) > maxRuleNameLength || strings.ContainsAny(name, ",:/ ") {
+		return fmt.Errorf("name is invalid: up to %d characters without spaces, ',', ':' or '/'", maxRuleNameLength)
+	}
+	// Под этим именем в аналитику попадают переходы, которым не подошло ни одно правило
+	if name == linkstore.DefaultRoute {
+		return fmt.Errorf("name '%s' is reserved", name)
+	}
+	if names[name] {
+		return fmt.Errorf("name '%s' is already used", name)
+	}
+	names[name] = true
+	return nil
+}
+
+// normalizeSchedule проверяет окна расписания: время "HH:MM", дни недели mon..sun в нижнем регистре
+func normalizeSchedule(windows []TimeWindow) ([]TimeWindow, error) {
+	if len(windows) > maxTimeWindows {
+		return nil, fmt.Errorf("no more than %d schedule windows are allowed", maxTimeWindows)
+	}
+	var normalized []TimeWindow
+	for _, window := range windows {
+		from, err := linkstore.ParseClock(strings.TrimSpace(window.From))
+		if err != nil {
+			return nil, err
+		}
+		until, err := linkstore.ParseClock(strings.TrimSpace(window.Until))
+		if err != nil {
+			return nil, err
+		}
+		if from == until || from == 24*60 {
+			return nil, fmt.Errorf("schedule window %s-%s is empty", window.From, window.Until)
+		}
+		days, err := normalizeConditions(window.Days, linkstore.Weekdays)
+		if err != nil {
+			return nil, err
+		}
+		normalized = append(normalized, TimeWindow{
+			Days:  days,
+			From:  strings.TrimSpace(window.From),
+			Until: strings.TrimSpace(window.Until),
+		})
+	}
+	return normalized, nil
+}
+
+// normalizeTimeZone проверяет часовой пояс IANA для расписаний; "UTC" хранится как пустое значение
+func normalizeTimeZone(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || name == "UTC" {
+		return "", nil
+	}
+	if _, err := linkstore.LoadLocation(name); err != nil || strings.EqualFold(name, "Local") {
+		return "", fmt.Errorf("unknown time zone '%s', use an IANA name such as Europe/Berlin", name)
+	}
+	return name, nil
+}
+
+// normalizeCountries приводит коды стран к верхнему регистру и проверяет формат ISO 3166-1 alpha-2
+func normalizeCountries(values []string) ([]string, error) {
+	var normalized []string
+	for _, value := range values {
+		value = strings.ToUpper(strings.TrimSpace(value))
+		if len(value) != 2 || value[0] < 'A' || value[0] > 'Z' || value[1] < 'A' || value[1] > 'Z' {
+			return nil, fmt.Errorf("country '%s' is invalid: use ISO 3166-1 alpha-2 codes, e.g. DE", value)
+		}
+		if !contains(normalized, value) {
+			normalized = append(normalized, value)
+		}
+	}
+	return normalized, nil
+}
+
+// normalizeConditions приводит значения условия к нижнему регистру, убирает повторы и проверяет, что они известны
+func normalizeConditions(values, known []string) ([]string, error) {
+	var normalized []string
+	for _, value := range values {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if !contains(known, value) {
+			return nil, fmt.Errorf("unknown value '%s' (allowed: %s)", value, strings.Join(known, ", "))
+		}
+		if !contains(normalized, value) {
+			normalized = append(normalized, value)
+		}
+	}
+	return normalized, nil
+}
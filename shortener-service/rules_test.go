@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/itcaat/url-shortener-demo/pkg/linkstore"
+)
+
+func TestNormalizeRulesRejectsReservedName(t *testing.T) {
+	_, fieldErrors := normalizeRules([]LinkRule{
+		{Name: "Default", Platforms: []string{"ios"}, URL: "https://example.com/ios"},
+	})
+	if len(fieldErrors) != 1 || fieldErrors[0].Field != "rules[0].name" {
+		t.Errorf("normalizeRules errors = %+v, want one on rules[0].name", fieldErrors)
+	}
+
+	_, fieldErrors = normalizeVariants([]LinkVariant{
+		{Name: linkstore.DefaultRoute, URL: "https://example.com/a", Weight: 1},
+		{URL: "https://example.com/b", Weight: 1},
+	})
+	if len(fieldErrors) != 1 || fieldErrors[0].Field != "variants[0].name" {
+		t.Errorf("normalizeVariants errors = %+v, want one on variants[0].name", fieldErrors)
+	}
+}
+
+func TestNormalizeRulesNames(t *testing.T) {
+	rules, fieldErrors := normalizeRules([]LinkRule{
+		{Platforms: []string{"iOS"}, Countries: []string{"de"}, URL: "https://example.com/ios"},
+		{Name: "Weekend", Schedule: []TimeWindow{{Days: []string{"sat", "sun"}, From: "00:00", Until: "24:00"}}, URL: "https://example.com/weekend"},
+		{Name: "weekend", Devices: []string{"tablet"}, URL: "https://example.com/tablet"},
+	})
+	if len(fieldErrors) != 1 || fieldErrors[0].Field != "rules[2].name" {
+		t.Fatalf("normalizeRules errors = %+v, want a duplicate name on rules[2].name", fieldErrors)
+	}
+
+	rules, fieldErrors = normalizeRules([]LinkRule{
+		{Platforms: []string{"iOS"}, Countries: []string{"de"}, URL: "https://example.com/ios"},
+	})
+	if len(fieldErrors) != 0 {
+		t.Fatalf("normalizeRules errors = %+v", fieldErrors)
+	}
+	if rules[0].Name != "ios+de" {
+		t.Errorf("generated name = %q, want ios+de", rules[0].Name)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"math/bits"
+)
+
+const (
+	obfuscationFeistel = "feistel"
+	obfuscationNone    = "none"
+
+	// Ключ счётчика кодов в хранилище
+	codeCounterKey = "counter:codes"
+
+	feistelRounds = 4
+)
+
+// counterGenerator строит коды из атомарного счётчика в хранилище (INCR в Redis).
+// Номер записывается в алфавите формата домена и, если включена обфускация, переставляется сетью Фейстеля
+// внутри пространства кодов той же длины: коды не повторяются и не идут подряд.
+type counterGenerator struct {
+	obfuscate bool
+	secret    []byte
+}
+
+func newCounterGenerator(obfuscation, secret string) (*counterGenerator, error) {
+	switch obfuscation {
+	case obfuscationFeistel:
+		if secret == "" {
+			log.Println("[Shortener Service] ⚠️  CODE_SECRET is not set, counter-based codes can be predicted")
+		}
+		return &counterGenerator{obfuscate: true, secret: []byte(secret)}, nil
+	case obfuscationNone:
+		return &counterGenerator{}, nil
+	default:
+		return nil, fmt.Errorf("unknown CODE_OBFUSCATION %q (expected %s or %s)", obfuscation, obfuscationFeistel, obfuscationNone)
+	}
+}
+
+func (g *counterGenerator) Unique() bool {
+	return true
+}
+
+func (g *counterGenerator) Next(format *CodeFormat, length int) (string, error) {
+	n, err := store.Incr(ctx, codeCounterKey)
+	if err != nil {
+		return "", fmt.Errorf("increment code counter: %w", err)
+	}
+
+	// Длина - наименьшая, при которой номер помещается в пространство кодов.
+	// Коды разной длины не пересекаются, поэтому перестановка внутри длины сохраняет уникальность.
+	value := uint64(n)
+	base := uint64(len(format.Alphabet))
+	space := uint64(1)
+	for i := 0; i < length && space <= math.MaxInt64/base; i++ {
+		space *= base
+	}
+	for value >= space {
+		// Пространство длиннее уже не помещается в счётчик
+		if length >= format.MaxLength || space > math.MaxInt64/base {
+			return "", errCodeSpaceExhausted
+		}
+		length++
+		space *= base
+	}
+
+	if g.obfuscate {
+		value = g.permute(value, space, length)
+	}
+	return encodeCode(value, format.Alphabet, length), nil
+}
+
+// permute - биекция на [0, space): сеть Фейстеля на чётном числе бит с "cycle walking",
+// пока значение не вернётся в пространство (в среднем не больше 4 итераций)
+func (g *counterGenerator) permute(value, space uint64, length int) uint64 {
+	width := bits.Len64(space - 1)
+	width += width % 2
+	half := uint(width / 2)
+	mask := uint64(1)<<half - 1
+
+	for {
+		left, right := value>>half, value&mask
+		for round := 0; round < feistelRounds; round++ {
+			left, right = right, left^(g.round(round, length, right)&mask)
+		}
+		value = left<<half | right
+		if value < space {
+			return value
+		}
+	}
+}
+
+// round - раундовая функция: HMAC-SHA256 от номера раунда, длины кода и половины значения
+func (g *counterGenerator) round(round, length int, half uint64) uint64 {
+	var msg [10]byte
+	msg[0] = byte(round)
+	msg[1] = byte(length)
+	binary.BigEndian.PutUint64(msg[2:], half)
+
+	mac := hmac.New(sha256.New, g.secret)
+	mac.Write(msg[:])
+	return binary.BigEndian.Uint64(mac.Sum(nil))
+}
+
+// encodeCode записывает число в алфавите, дополняя слева до length символов
+func encodeCode(value uint64, alphabet string, length int) string {
+	base := uint64(len(alphabet))
+	result := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		result[i] = alphabet[value%base]
+		value /= base
+	}
+	return string(result)
+}
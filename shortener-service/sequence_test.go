@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/itcaat/url-shortener-demo/pkg/linkstore"
+)
+
+// useMemoryStore подменяет глобальное хранилище на время теста
+func useMemoryStore(t *testing.T) {
+	previous := store
+	store = linkstore.NewMemory()
+	t.Cleanup(func() { store = previous })
+}
+
+func TestPermuteIsBijection(t *testing.T) {
+	g := &counterGenerator{obfuscate: true, secret: []byte("secret")}
+	for _, space := range []uint64{2, 10, 36, 62, 1000, 62 * 62} {
+		t.Run(fmt.Sprint(space), func(t *testing.T) {
+			seen := make(map[uint64]bool, space)
+			for value := uint64(0); value < space; value++ {
+				permuted := g.permute(value, space, 2)
+				if permuted >= space {
+					t.Fatalf("permute(%d) = %d, outside [0, %d)", value, permuted, space)
+				}
+				if seen[permuted] {
+					t.Fatalf("permute(%d) = %d, repeats an earlier value", value, permuted)
+				}
+				seen[permuted] = true
+			}
+		})
+	}
+}
+
+func TestPermuteDependsOnSecret(t *testing.T) {
+	a := &counterGenerator{obfuscate: true, secret: []byte("one")}
+	b := &counterGenerator{obfuscate: true, secret: []byte("two")}
+	same := 0
+	for value := uint64(0); value < 1000; value++ {
+		if a.permute(value, 1000, 3) == b.permute(value, 1000, 3) {
+			same++
+		}
+	}
+	if same > 100 {
+		t.Errorf("%d of 1000 values map identically under different secrets", same)
+	}
+}
+
+func TestCounterGeneratorNext(t *testing.T) {
+	tests := []struct {
+		obfuscation string
+		sequential  bool
+	}{
+		{obfuscationNone, true},
+		{obfuscationFeistel, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.obfuscation, func(t *testing.T) {
+			useMemoryStore(t)
+			g, err := newCounterGenerator(tt.obfuscation, "secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+			format := &CodeFormat{Alphabet: "0123456789", MinLength: 1, MaxLength: 2}
+
+			// Счётчик начинается с 1: 9 однозначных кодов, затем 90 двузначных
+			seen := map[string]bool{}
+			sequential := true
+			for n := 1; n < 100; n++ {
+				code, err := g.Next(format, 1)
+				if err != nil {
+					t.Fatalf("Next #%d: %v", n, err)
+				}
+				wantLength := 1
+				if n >= 10 {
+					wantLength = 2
+				}
+				if len(code) != wantLength {
+					t.Errorf("Next #%d = %q, want length %d", n, code, wantLength)
+				}
+				if seen[code] {
+					t.Fatalf("Next #%d = %q, repeats an earlier code", n, code)
+				}
+				seen[code] = true
+				if code != encodeCode(uint64(n), format.Alphabet, wantLength) {
+					sequential = false
+				}
+			}
+			if sequential != tt.sequential {
+				t.Errorf("codes sequential = %v, want %v", sequential, tt.sequential)
+			}
+
+			if _, err := g.Next(format, 1); !errors.Is(err, errCodeSpaceExhausted) {
+				t.Errorf("Next past MaxLength error = %v, want errCodeSpaceExhausted", err)
+			}
+		})
+	}
+}
+
+func TestNewCounterGeneratorUnknownObfuscation(t *testing.T) {
+	if _, err := newCounterGenerator("rot13", ""); err == nil {
+		t.Error("newCounterGenerator accepted an unknown obfuscation")
+	}
+}
+
+func TestEncodeCode(t *testing.T) {
+	tests := []struct {
+		value    uint64
+		alphabet string
+		length   int
+		want     string
+	}{
+		{0, "0123456789", 1, "0"},
+		{5, "0123456789", 3, "005"},
+		{35, "0123456789abcdefghijklmnopqrstuvwxyz", 2, "0z"},
+		{36, "0123456789abcdefghijklmnopqrstuvwxyz", 2, "10"},
+		{3, "ab", 3, "abb"},
+	}
+	for _, tt := range tests {
+		if got := encodeCode(tt.value, tt.alphabet, tt.length); got != tt.want {
+			t.Errorf("encodeCode(%d, %q, %d) = %q, want %q", tt.value, tt.alphabet, tt.length, got, tt.want)
+		}
+	}
+}
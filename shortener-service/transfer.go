@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+
+	conflictSkip      = "skip"
+	conflictOverwrite = "overwrite"
+	conflictFail      = "fail"
+
+	// Размер пачки строк, записываемых в хранилище одной пакетной операцией
+	importChunkSize = 500
+)
+
+var (
+	importMaxRows            = getEnvInt("IMPORT_MAX_ROWS", 100000)
+	importMaxBodyBytes int64 = 50 << 20
+)
+
+// LinkExport - формат ссылки при импорте и экспорте (строка CSV или NDJSON)
+type LinkExport struct {
+	Code        string     `json:"code"`
+	Domain      string     `json:"domain,omitempty"`
+	Destination string     `json:"destination"`
+	Title       string     `json:"title,omitempty"`
+	Owner       string     `json:"owner,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+	State       string     `json:"state,omitempty"`
+	CreatedAt   *time.Time `json:"createdAt,omitempty"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	// Protected отмечает ссылку с паролем. Хеш пароля выгружается только по флагу
+	// -include-password-hashes командной строки и переносится как есть, сам пароль не восстановить.
+	Protected    bool       `json:"protected,omitempty"`
+	PasswordHash string     `json:"passwordHash,omitempty"`
+	MaxClicks    int64      `json:"maxClicks,omitempty"`
+	ActiveFrom   *time.Time `json:"activeFrom,omitempty"`
+	ActiveUntil  *time.Time `json:"activeUntil,omitempty"`
+	FallbackURL  string     `json:"fallbackUrl,omitempty"`
+	// В CSV правила и варианты хранятся JSON-массивами в одной колонке
+	Rules            []LinkRule    `json:"rules,omitempty"`
+	TimeZone         string        `json:"timeZone,omitempty"`
+	Variants         []LinkVariant `json:"variants,omitempty"`
+	QueryPassthrough string        `json:"queryPassthrough,omitempty"`
+	PathPassthrough  bool          `json:"pathPassthrough,omitempty"`
+	RedirectStatus   int           `json:"redirectStatus,omitempty"`
+}
+
+type importOptions struct {
+	Format     string
+	DryRun     bool
+	OnConflict string
+	Domain     string // домен для строк без колонки domain
+}
+
+// ImportRowResult - результат по строке импорта; в отчёт попадают только строки, требующие внимания
+type ImportRowResult struct {
+	Line   int          `json:"line"`
+	Code   string       `json:"code,omitempty"`
+	Domain string       `json:"domain,omitempty"`
+	Status string       `json:"status"`
+	Error  string       `json:"error,omitempty"`
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+type ImportReport struct {
+	DryRun      bool              `json:"dryRun"`
+	OnConflict  string            `json:"onConflict"`
+	Total       int               `json:"total"`
+	Created     int               `json:"created"`
+	Overwritten int               `json:"overwritten"`
+	Skipped     int               `json:"skipped"`
+	Failed      int               `json:"failed"`
+	Aborted     bool              `json:"aborted,omitempty"`
+	Rows        []ImportRowResult `json:"rows"`
+}
+
+// importRow - разобранная строка импорта
+type importRow struct {
+	Line   int
+	Data   LinkExport
+	Err    error
+	Link   *Link
+	KeyTTL time.Duration
+}
+
+func (o importOptions) validate() error {
+	if o.Format != formatCSV && o.Format != formatNDJSON {
+		return fmt.Errorf("format must be '%s' or '%s'", formatCSV, formatNDJSON)
+	}
+	switch o.OnConflict {
+	case conflictSkip, conflictOverwrite, conflictFail:
+	default:
+		return fmt.Errorf("onConflict must be one of %s, %s, %s", conflictSkip, conflictOverwrite, conflictFail)
+	}
+	if _, ok := resolveDomain(o.Domain); !ok {
+		return fmt.Errorf("domain '%s' is not configured", o.Domain)
+	}
+	return nil
+}
+
+// detectFormat определяет формат по явному параметру, Content-Type или расширению файла
+func detectFormat(explicit, contentType, filename string) string {
+	switch {
+	case explicit != "":
+		return strings.ToLower(explicit)
+	case strings.Contains(contentType, "csv"), strings.HasSuffix(filename, ".csv"):
+		return formatCSV
+	default:
+		return formatNDJSON
+	}
+}
+
+func importLinksHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	opts := importOptions{
+		Format:     detectFormat(params.Get("format"), r.Header.Get("Content-Type"), ""),
+		DryRun:     params.Get("dryRun") == "true",
+		OnConflict: params.Get("onConflict"),
+		Domain:     params.Get("domain"),
+	}
+	if opts.OnConflict == "" {
+		opts.OnConflict = conflictSkip
+	}
+	if err := opts.validate(); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rows, err := readImportRows(http.MaxBytesReader(w, r.Body, importMaxBodyBytes), opts.Format)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	report, err := importLinks(rows, opts)
+	if err != nil {
+		log.Printf("[Shortener Service] Import failed: %v\n", err)
+		respondError(w, http.StatusInternalServerError, "Import failed")
+		return
+	}
+
+	log.Printf("[Shortener Service] Import (dry-run: %v): %d created, %d overwritten, %d skipped, %d failed\n",
+		report.DryRun, report.Created, report.Overwritten, report.Skipped, report.Failed)
+
+	status := http.StatusOK
+	if report.Aborted {
+		status = http.StatusConflict
+	}
+	respondJSON(w, status, report)
+}
+
+func exportLinksHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	format := detectFormat(params.Get("format"), "", "")
+	if format != formatCSV && format != formatNDJSON {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("format must be '%s' or '%s'", formatCSV, formatNDJSON))
+		return
+	}
+
+	var scope *string
+	if params.Has("domain") {
+		domain, ok := resolveDomain(params.Get("domain"))
+		if !ok {
+			respondError(w, http.StatusBadRequest, "Unknown domain")
+			return
+		}
+		scope = &domain.Scope
+	}
+
+	contentType := "application/x-ndjson"
+	if format == formatCSV {
+		contentType = "text/csv; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="links.%s"`, format))
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	// Хеши паролей через HTTP не выгружаются: API не требует авторизации
+	exported, err := exportLinks(w, format, scope, false, func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	})
+	if err != nil {
+		// Заголовки уже отправлены - остаётся только оборвать выгрузку
+		log.Printf("[Shortener Service] Export failed after %d links: %v\n", exported, err)
+		return
+	}
+	log.Printf("[Shortener Service] Exported %d links\n", exported)
+}
+
+// runImport загружает ссылки из файла или stdin и печатает отчёт в формате JSON.
+// Запуск: shortener-service import [-file links.csv] [-format csv|ndjson] [-on-conflict skip|overwrite|fail] [-domain name] [-dry-run]
+func runImport(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	file := flags.String("file", "-", "input file, '-' for stdin")
+	format := flags.String("format", "", "csv or ndjson (detected from the file extension by default)")
+	onConflict := flags.String("on-conflict", conflictSkip, "what to do with existing codes: skip, overwrite or fail")
+	domain := flags.String("domain", "", "domain for rows without a domain column")
+	dryRun := flags.Bool("dry-run", false, "validate and report conflicts without writing")
+	flags.Parse(args)
+
+	initDomains()
+	initStore()
+	defer store.Close()
+	initCodeGenerator()
+	initCodeFilter()
+
+	input := io.Reader(os.Stdin)
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			log.Fatalf("[Shortener Service] Failed to open import file: %v", err)
+		}
+		defer f.Close()
+		input = f
+	}
+
+	opts := importOptions{
+		Format:     detectFormat(*format, "", *file),
+		DryRun:     *dryRun,
+		OnConflict: *onConflict,
+		Domain:     *domain,
+	}
+	if err := opts.validate(); err != nil {
+		log.Fatalf("[Shortener Service] %v", err)
+	}
+
+	rows, err := readImportRows(input, opts.Format)
+	if err != nil {
+		log.Fatalf("[Shortener Service] Failed to read import file: %v", err)
+	}
+	report, err := importLinks(rows, opts)
+	if err != nil {
+		log.Fatalf("[Shortener Service] Import failed: %v", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(report)
+
+	log.Printf("[Shortener Service] Import (dry-run: %v): %d created, %d overwritten, %d skipped, %d failed\n",
+		report.DryRun, report.Created, report.Overwritten, report.Skipped, report.Failed)
+	if report.Aborted {
+		os.Exit(1)
+	}
+}
+
+// runExport выгружает все ссылки в файл или stdout. Хеши паролей выгружаются только с
+// -include-password-hashes - для переноса защищённых ссылок администратором.
+// Запуск: shortener-service export [-file links.ndjson] [-format csv|ndjson] [-domain name] [-include-password-hashes]
+func runExport(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	file := flags.String("file", "-", "output file, '-' for stdout")
+	format := flags.String("format", "", "csv or ndjson (detected from the file extension by default)")
+	domain := flags.String("domain", "", "export only links of this domain")
+	withPasswords := flags.Bool("include-password-hashes", false, "include password hashes of protected links")
+	flags.Parse(args)
+
+	initDomains()
+	initStore()
+	defer store.Close()
+
+	outputFormat := detectFormat(*format, "", *file)
+	if outputFormat != formatCSV && outputFormat != formatNDJSON {
+		log.Fatalf("[Shortener Service] format must be '%s' or '%s'", formatCSV, formatNDJSON)
+	}
+
+	var scope *string
+	if *domain != "" {
+		d, ok := resolveDomain(*domain)
+		if !ok {
+			log.Fatalf("[Shortener Service] domain '%s' is not configured", *domain)
+		}
+		scope = &d.Scope
+	}
+
+	output := io.Writer(os.Stdout)
+	if *file != "-" {
+		f, err := os.Create(*file)
+		if err != nil {
+			log.Fatalf("[Shortener Service] Failed to create export file: %v", err)
+		}
+		defer f.Close()
+		output = f
+	}
+
+	exported, err := exportLinks(output, outputFormat, scope, *withPasswords, nil)
+	if err != nil {
+		log.Fatalf("[Shortener Service] Export failed after %d links: %v", exported, err)
+	}
+	log.Printf("[Shortener Service] Exported %d links\n", exported)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// importStatuses возвращает статусы строк отчёта импорта по кодам
+func importStatuses(report ImportReport) map[string]string {
+	statuses := map[string]string{}
+	for _, row := range report.Rows {
+		statuses[row.Code] = row.Status
+	}
+	return statuses
+}
+
+func TestImportKeepsLegacyCodes(t *testing.T) {
+	testService(t)
+
+	body := strings.Join([]string{
+		`{"code": "ab", "destination": "https://example.com/short"}`,
+		`{"code": "sex", "destination": "https://example.com/blocked-word"}`,
+		`{"code": "Spring-Sale_2024", "destination": "https://example.com/sale"}`,
+		`{"code": "api", "destination": "https://example.com/reserved"}`,
+		`{"code": "bad code", "destination": "https://example.com/space"}`,
+	}, "\n")
+	w := serve("POST", "/links/import?format=ndjson", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body.String())
+	}
+	var report ImportReport
+	decodeBody(t, w, &report)
+	if report.Created != 3 || report.Failed != 2 {
+		t.Errorf("report = %+v, want 3 created and 2 failed", report)
+	}
+	statuses := importStatuses(report)
+	for _, code := range []string{"api", "bad code"} {
+		if statuses[code] != "invalid" {
+			t.Errorf("code %q: status %q, want invalid", code, statuses[code])
+		}
+	}
+	for _, code := range []string{"ab", "sex", "Spring-Sale_2024"} {
+		if _, err := store.Get(ctx, code); err != nil {
+			t.Errorf("code %q was not imported: %v", code, err)
+		}
+	}
+}
+
+func TestImportConflictPolicies(t *testing.T) {
+	tests := []struct {
+		onConflict  string
+		status      int
+		destination string
+		row         string
+	}{
+		{conflictSkip, http.StatusOK, "https://example.com/old", "skipped"},
+		{conflictOverwrite, http.StatusOK, "https://example.com/new", "overwritten"},
+		{conflictFail, http.StatusConflict, "https://example.com/old", "conflict"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.onConflict, func(t *testing.T) {
+			testService(t)
+			serve("POST", "/links/import?format=ndjson", `{"code": "taken", "destination": "https://example.com/old"}`)
+
+			body := "code,long_url\ntaken,https://example.com/new\nfresh,https://example.com/fresh\n"
+			w := serve("POST", "/links/import?format=csv&onConflict="+tt.onConflict, body)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			var report ImportReport
+			decodeBody(t, w, &report)
+			if got := importStatuses(report)["taken"]; got != tt.row {
+				t.Errorf("row status = %q, want %q", got, tt.row)
+			}
+			if record, err := store.Get(ctx, "taken"); err != nil || record.URL != tt.destination {
+				t.Errorf("taken = %+v, %v; want %s", record, err, tt.destination)
+			}
+			// Политика fail не записывает ничего
+			_, err := store.Get(ctx, "fresh")
+			if created := err == nil; created != (tt.onConflict != conflictFail) {
+				t.Errorf("fresh created = %v", created)
+			}
+		})
+	}
+}
+
+func TestImportDryRunWritesNothing(t *testing.T) {
+	testService(t)
+	w := serve("POST", "/links/import?format=ndjson&dryRun=true", `{"code": "dry", "destination": "https://example.com/"}`)
+	var report ImportReport
+	decodeBody(t, w, &report)
+	if w.Code != http.StatusOK || !report.DryRun || report.Created != 1 {
+		t.Fatalf("response = %d %+v, want a dry-run report with 1 created", w.Code, report)
+	}
+	if _, err := store.Get(ctx, "dry"); err == nil {
+		t.Error("dry run wrote the link")
+	}
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	testService(t)
+	const hash = "pbkdf2-sha256$1000$MDEyMzQ1Njc4OWFiY2RlZg$cBg8D2DungRB9k76szThf5ehfyBz991ay6PT8Srwk4M"
+	body := strings.Join([]string{
+		`{"code": "plain", "destination": "https://example.com/plain", "tags": ["a", "b"], "maxClicks": 3}`,
+		`{"code": "secret", "destination": "https://example.com/secret", "protected": true, "passwordHash": "` + hash + `"}`,
+	}, "\n")
+	if w := serve("POST", "/links/import?format=ndjson", body); w.Code != http.StatusOK {
+		t.Fatalf("import status = %d: %s", w.Code, w.Body.String())
+	}
+
+	w := serve("GET", "/links/export?format=ndjson", "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("export = %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	exported := map[string]LinkExport{}
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var data LinkExport
+		if err := json.Unmarshal(scanner.Bytes(), &data); err != nil {
+			t.Fatalf("decode %q: %v", scanner.Text(), err)
+		}
+		exported[data.Code] = data
+	}
+	if plain := exported["plain"]; plain.Destination != "https://example.com/plain" || len(plain.Tags) != 2 || plain.MaxClicks != 3 {
+		t.Errorf("plain = %+v", plain)
+	}
+	// Хеши паролей через HTTP не выгружаются
+	if secret := exported["secret"]; !secret.Protected || secret.PasswordHash != "" {
+		t.Errorf("secret = %+v, want protected without a hash", secret)
+	}
+
+	csvExport := serve("GET", "/links/export?format=csv", "")
+	lines := strings.Split(strings.TrimSpace(csvExport.Body.String()), "\n")
+	if len(lines) != 3 || lines[0] != strings.Join(exportColumns, ",") {
+		t.Fatalf("CSV export = %q", csvExport.Body.String())
+	}
+
+	// Выгрузка без хешей не превращает защищённую ссылку в открытую при обратном импорте
+	testService(t)
+	w = serve("POST", "/links/import?format=csv", csvExport.Body.String())
+	var report ImportReport
+	decodeBody(t, w, &report)
+	statuses := importStatuses(report)
+	if report.Created != 1 || statuses["secret"] != "invalid" {
+		t.Errorf("re-import = %+v, want plain created and secret rejected", report)
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var exportColumns = []string{"code", "domain", "destination", "title", "owner", "tags", "state", "createdAt", "expiresAt", "protected", "maxClicks",
+	"activeFrom", "activeUntil", "fallbackUrl", "rules", "timeZone", "variants",
+	"queryPassthrough", "pathPassthrough", "redirectStatus"}
+
+// exportPasswordColumn - дополнительная последняя колонка выгрузки с хешами паролей
+const exportPasswordColumn = "passwordHash"
+
+// Синонимы колонок CSV, в том числе из выгрузок Bitly и подобных сервисов
+var importColumnAliases = map[string]string{
+	"code":              "code",
+	"short_code":        "code",
+	"shortcode":         "code",
+	"alias":             "code",
+	"back_half":         "code",
+	"link":              "code",
+	"short_url":         "code",
+	"shorturl":          "code",
+	"domain":            "domain",
+	"destination":       "destination",
+	"url":               "destination",
+	"long_url":          "destination",
+	"longurl":           "destination",
+	"original_url":      "destination",
+	"originalurl":       "destination",
+	"title":             "title",
+	"owner":             "owner",
+	"tags":              "tags",
+	"state":             "state",
+	"createdat":         "createdAt",
+	"created_at":        "createdAt",
+	"expiresat":         "expiresAt",
+	"expires_at":        "expiresAt",
+	"expiry":            "expiresAt",
+	"expiration":        "expiresAt",
+	"protected":         "protected",
+	"passwordhash":      "passwordHash",
+	"password_hash":     "passwordHash",
+	"maxclicks":         "maxClicks",
+	"max_clicks":        "maxClicks",
+	"activefrom":        "activeFrom",
+	"active_from":       "activeFrom",
+	"starts_at":         "activeFrom",
+	"activeuntil":       "activeUntil",
+	"active_until":      "activeUntil",
+	"ends_at":           "activeUntil",
+	"fallbackurl":       "fallbackUrl",
+	"fallback_url":      "fallbackUrl",
+	"rules":             "rules",
+	"timezone":          "timeZone",
+	"time_zone":         "timeZone",
+	"variants":          "variants",
+	"querypassthrough":  "queryPassthrough",
+	"query_passthrough": "queryPassthrough",
+	"pathpassthrough":   "pathPassthrough",
+	"path_passthrough":  "pathPassthrough",
+	"redirectstatus":    "redirectStatus",
+	"redirect_status":   "redirectStatus",
+	"redirect_type":     "redirectStatus",
+}
+
+// readImportRows читает строки CSV (с заголовком) или NDJSON
+func readImportRows(r io.Reader, format string) ([]*importRow, error) {
+	if format == formatNDJSON {
+		return readNDJSONRows(r)
+	}
+	return readCSVRows(r)
+}
+
+func readNDJSONRows(r io.Reader) ([]*importRow, error) {
+	var rows []*importRow
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if len(rows) >= importMaxRows {
+			return nil, fmt.Errorf("no more than %d rows are allowed per import", importMaxRows)
+		}
+
+		row := &importRow{Line: line}
+		if err := json.Unmarshal([]byte(text), &row.Data); err != nil {
+			row.Err = errors.New("invalid JSON")
+		}
+		rows = append(rows, row)
+	}
+	return rows, scanner.Err()
+}
+
+func readCSVRows(r io.Reader) ([]*importRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read CSV header: %w", err)
+	}
+
+	columns := make([]string, len(header))
+	hasDestination := false
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		columns[i] = importColumnAliases[name]
+		if columns[i] == "destination" {
+			hasDestination = true
+		}
+	}
+	if !hasDestination {
+		return nil, errors.New("CSV header must contain a destination column (destination, url or long_url)")
+	}
+
+	var rows []*importRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if len(rows) >= importMaxRows {
+			return nil, fmt.Errorf("no more than %d rows are allowed per import", importMaxRows)
+		}
+
+		row := &importRow{}
+		rows = append(rows, row)
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			row.Line = parseErr.Line
+			row.Err = fmt.Errorf("invalid CSV row: %v", parseErr.Err)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		row.Line, _ = reader.FieldPos(0)
+		row.Err = fillFromCSV(&row.Data, columns, record)
+	}
+	return rows, nil
+}
+
+func fillFromCSV(data *LinkExport, columns, record []string) error {
+	for i, value := range record {
+		if i >= len(columns) {
+			break
+		}
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		switch columns[i] {
+		case "code":
+			data.Code = codeFromShortLink(value)
+		case "domain":
+			data.Domain = value
+		case "destination":
+			data.Destination = value
+		case "title":
+			data.Title = value
+		case "owner":
+			data.Owner = value
+		case "tags":
+			data.Tags = strings.FieldsFunc(value, func(r rune) bool {
+				return r == '|' || r == ';' || r == ','
+			})
+		case "state":
+			data.State = value
+		case "createdAt":
+			t, err := parseImportTime(value)
+			if err != nil {
+				return fmt.Errorf("invalid createdAt: %v", err)
+			}
+			data.CreatedAt = &t
+		case "expiresAt":
+			t, err := parseImportTime(value)
+			if err != nil {
+				return fmt.Errorf("invalid expiresAt: %v", err)
+			}
+			data.ExpiresAt = &t
+		case "protected":
+			protected, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid protected: %v", err)
+			}
+			data.Protected = protected
+		case "passwordHash":
+			data.PasswordHash = value
+		case "maxClicks":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid maxClicks: %v", err)
+			}
+			data.MaxClicks = n
+		case "activeFrom":
+			t, err := parseImportTime(value)
+			if err != nil {
+				return fmt.Errorf("invalid activeFrom: %v", err)
+			}
+			data.ActiveFrom = &t
+		case "activeUntil":
+			t, err := parseImportTime(value)
+			if err != nil {
+				return fmt.Errorf("invalid activeUntil: %v", err)
+			}
+			data.ActiveUntil = &t
+		case "fallbackUrl":
+			data.FallbackURL = value
+		case "rules":
+			if err := json.Unmarshal([]byte(value), &data.Rules); err != nil {
+				return fmt.Errorf("invalid rules: %v", err)
+			}
+		case "timeZone":
+			data.TimeZone = value
+		case "queryPassthrough":
+			data.QueryPassthrough = value
+		case "pathPassthrough":
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid pathPassthrough: %v", err)
+			}
+			data.PathPassthrough = enabled
+		case "redirectStatus":
+			status, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid redirectStatus: %v", err)
+			}
+			data.RedirectStatus = status
+		case "variants":
+			if err := json.Unmarshal([]byte(value), &data.Variants); err != nil {
+				return fmt.Errorf("invalid variants: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// codeFromShortLink извлекает код из полной короткой ссылки ("bit.ly/abc" -> "abc")
+func codeFromShortLink(value string) string {
+	if !strings.Contains(value, "/") {
+		return value
+	}
+	if !strings.Contains(value, "://") {
+		value = "https://" + value
+	}
+	if u, err := url.Parse(value); err == nil {
+		return strings.Trim(u.Path, "/")
+	}
+	return value
+}
+
+func parseImportTime(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unsupported time format '%s'", value)
+}
+
+func (d LinkExport) csvRecord(withPasswords bool) []string {
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+	formatCount := func(n int64) string {
+		if n == 0 {
+			return ""
+		}
+		return strconv.FormatInt(n, 10)
+	}
+	formatFlag := func(enabled bool) string {
+		if !enabled {
+			return ""
+		}
+		return "true"
+	}
+	formatJSON := func(items any, n int) string {
+		if n == 0 {
+			return ""
+		}
+		data, _ := json.Marshal(items)
+		return string(data)
+	}
+	record := []string{
+		d.Code,
+		d.Domain,
+		d.Destination,
+		d.Title,
+		d.Owner,
+		strings.Join(d.Tags, "|"),
+		d.State,
+		formatTime(d.CreatedAt),
+		formatTime(d.ExpiresAt),
+		formatFlag(d.Protected),
+		formatCount(d.MaxClicks),
+		formatTime(d.ActiveFrom),
+		formatTime(d.ActiveUntil),
+		d.FallbackURL,
+		formatJSON(d.Rules, len(d.Rules)),
+		d.TimeZone,
+		formatJSON(d.Variants, len(d.Variants)),
+		d.QueryPassthrough,
+		formatFlag(d.PathPassthrough),
+		formatCount(int64(d.RedirectStatus)),
+	}
+	if withPasswords {
+		record = append(record, d.PasswordHash)
+	}
+	return record
+}
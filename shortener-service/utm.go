@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/itcaat/url-shortener-demo/pkg/linkstore"
+)
+
+const (
+	maxUTMValueLength = 200
+	maxUTMPresets     = 100
+	maxPresetNameLen  = 32
+
+	// Все пресеты хранятся одним JSON-документом: их немного, и список читается целиком
+	utmPresetsKey = "utm-presets"
+	// Сколько раз изменение пресетов повторяется, если документ параллельно изменила другая реплика
+	utmPresetsMaxAttempts = 10
+)
+
+var (
+	errUTMPresetNotFound = errors.New("UTM preset not found")
+	errTooManyUTMPresets = fmt.Errorf("no more than %d UTM presets are allowed", maxUTMPresets)
+)
+
+// UTMParams - метки кампании (utm_source, utm_medium, utm_campaign, utm_term, utm_content)
+type UTMParams = linkstore.UTM
+
+// UTMPreset - именованный набор меток, который можно указать при создании ссылки вместо полей utm
+type UTMPreset struct {
+	Name string `json:"name"`
+	UTMParams
+}
+
+type UTMPresetListResponse struct {
+	Presets []UTMPreset `json:"presets"`
+	Total   int         `json:"total"`
+}
+
+// loadUTMPresets читает все пресеты; отсутствие документа - пустой набор
+func loadUTMPresets() (map[string]UTMParams, error) {
+	presets, _, err := readUTMPresets()
+	return presets, err
+}
+
+// readUTMPresets возвращает пресеты вместе с исходным документом для SwapRef; пустой документ - его нет
+func readUTMPresets() (map[string]UTMParams, string, error) {
+	value, err := store.GetRef(ctx, utmPresetsKey)
+	if err == linkstore.ErrNotFound {
+		return map[string]UTMParams{}, "", nil
+	} else if err != nil {
+		return nil, "", err
+	}
+	presets := map[string]UTMParams{}
+	if err := json.Unmarshal([]byte(value), &presets); err != nil {
+		return nil, "", err
+	}
+	return presets, value, nil
+}
+
+// updateUTMPresets применяет change к документу пресетов и записывает его, только если документ не изменился
+// после чтения. Иначе изменение повторяется на свежей версии, поэтому одновременные изменения
+// разных пресетов с нескольких реплик не перезаписывают друг друга.
+func updateUTMPresets(change func(presets map[string]UTMParams) error) error {
+	for attempt := 0; attempt < utmPresetsMaxAttempts; attempt++ {
+		presets, current, err := readUTMPresets()
+		if err != nil {
+			return err
+		}
+		if err := change(presets); err != nil {
+			return err
+		}
+		data, err := json.Marshal(presets)
+		if err != nil {
+			return err
+		}
+		swapped, err := store.SwapRef(ctx, utmPresetsKey, current, string(data))
+		if err != nil || swapped {
+			return err
+		}
+	}
+	return errors.New("UTM presets are being modified concurrently")
+}
+
+// normalizeUTM обрезает пробелы в метках и проверяет их длину; ошибки возвращаются по полям prefix<метка>
+func normalizeUTM(params UTMParams, prefix string) (UTMParams, []FieldError) {
+	var fieldErrors []FieldError
+	var normalized UTMParams
+	for _, f := range linkstore.UTMFields {
+		value := strings.TrimSpace(params.Get(f.Name))
+		if utf8.RuneCountInString(value) > maxUTMValueLength {
+			fieldErrors = append(fieldErrors, FieldError{Field: prefix + f.Name, Message: fmt.Sprintf("%s must not exceed %d characters", f.Name, maxUTMValueLength)})
+		}
+		normalized.Set(f.Name, value)
+	}
+	return normalized, fieldErrors
+}
+
+// resolveUTM собирает метки ссылки: сначала пресет, затем переданные поля utm поверх него.
+// Без source метки не имеют смысла для систем аналитики, поэтому он обязателен.
+func resolveUTM(params *UTMParams, presetName string) (UTMParams, []FieldError) {
+	var utm UTMParams
+	if presetName != "" {
+		presets, err := loadUTMPresets()
+		if err != nil {
+			log.Printf("[Shortener Service] Failed to load UTM presets: %v\n", err)
+			return UTMParams{}, []FieldError{{Field: "utmPreset", Message: "failed to load UTM presets"}}
+		}
+		preset, ok := presets[strings.ToLower(strings.TrimSpace(presetName))]
+		if !ok {
+			return UTMParams{}, []FieldError{{Field: "utmPreset", Message: fmt.Sprintf("UTM preset '%s' not found", presetName)}}
+		}
+		utm = preset
+	}
+
+	if params != nil {
+		normalized, fieldErrors := normalizeUTM(*params, "utm.")
+		if len(fieldErrors) > 0 {
+			return UTMParams{}, fieldErrors
+		}
+		utm = utm.Override(normalized)
+	}
+
+	if !utm.IsZero() && utm.Source == "" {
+		return UTMParams{}, []FieldError{{Field: "utm.source", Message: "source is required when campaign parameters are set"}}
+	}
+	return utm, nil
+}
+
+// applyUTM добавляет метки во все адреса перехода ссылки: основной, правил и вариантов A/B-теста.
+// Запасной адрес до начала окна активности не размечается.
+func applyUTM(record *LinkRecord, utm UTMParams) []FieldError {
+	if utm.IsZero() {
+		return nil
+	}
+
+	var fieldErrors []FieldError
+	tag := func(destination, field string) string {
+		tagged, err := linkstore.ApplyUTM(destination, utm)
+		if err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: field, Message: err.Error()})
+			return destination
+		}
+		if len(tagged) > maxURLLength {
+			fieldErrors = append(fieldErrors, FieldError{Field: field, Message: fmt.Sprintf("URL with campaign parameters must not exceed %d characters", maxURLLength)})
+		}
+		return tagged
+	}
+
+	record.URL = tag(record.URL, "url")
+	for i := range record.Rules {
+		record.Rules[i].URL = tag(record.Rules[i].URL, fmt.Sprintf("rules[%d].url", i))
+	}
+	for i := range record.Variants {
+		record.Variants[i].URL = tag(record.Variants[i].URL, fmt.Sprintf("variants[%d].url", i))
+	}
+	return fieldErrors
+}
+
+// linkUTM возвращает метки кампании из адреса ссылки; nil - адрес не размечен
+func linkUTM(destination string) *UTMParams {
+	parsed, err := url.Parse(destination)
+	if err != nil {
+		return nil
+	}
+	utm := linkstore.ParseUTM(parsed.Query())
+	if utm.IsZero() {
+		return nil
+	}
+	return &utm
+}
+
+// utmPresetName приводит имя пресета к нижнему регистру и проверяет его
+func utmPresetName(r *http.Request) (string, error) {
+	name := strings.ToLower(strings.TrimSpace(mux.Vars(r)["name"]))
+	if name == "" || len(name) > maxPresetNameLen || strings.ContainsAny(name, ",:/ ") {
+		return "", fmt.Errorf("preset name is invalid: up to %d characters without spaces, ',', ':' or '/'", maxPresetNameLen)
+	}
+	return name, nil
+}
+
+func listUTMPresetsHandler(w http.ResponseWriter, r *http.Request) {
+	presets, err := loadUTMPresets()
+	if err != nil {
+		log.Printf("[Shortener Service] Failed to load UTM presets: %v\n", err)
+		respondError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	response := UTMPresetListResponse{Presets: make([]UTMPreset, 0, len(presets))}
+	for name, params := range presets {
+		response.Presets = append(response.Presets, UTMPreset{Name: name, UTMParams: params})
+	}
+	sort.Slice(response.Presets, func(i, j int) bool {
+		return response.Presets[i].Name < response.Presets[j].Name
+	})
+	response.Total = len(response.Presets)
+	respondJSON(w, http.StatusOK, response)
+}
+
+func getUTMPresetHandler(w http.ResponseWriter, r *http.Request) {
+	name, err := utmPresetName(r)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "UTM preset not found")
+		return
+	}
+
+	presets, err := loadUTMPresets()
+	if err != nil {
+		log.Printf("[Shortener Service] Failed to load UTM presets: %v\n", err)
+		respondError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	params, ok := presets[name]
+	if !ok {
+		respondError(w, http.StatusNotFound, "UTM preset not found")
+		return
+	}
+	respondJSON(w, http.StatusOK, UTMPreset{Name: name, UTMParams: params})
+}
+
+// putUTMPresetHandler создаёт пресет или заменяет его целиком: PUT /utm-presets/{name}.
+// Ссылки, уже созданные по пресету, не меняются - метки записаны в их адресах.
+func putUTMPresetHandler(w http.ResponseWriter, r *http.Request) {
+	var params UTMParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	var fieldErrors []FieldError
+	name, err := utmPresetName(r)
+	if err != nil {
+		fieldErrors = append(fieldErrors, FieldError{Field: "name", Message: err.Error()})
+	}
+	params, utmErrors := normalizeUTM(params, "")
+	fieldErrors = append(fieldErrors, utmErrors...)
+	if len(utmErrors) == 0 && params.IsZero() {
+		fieldErrors = append(fieldErrors, FieldError{Field: "source", Message: "preset must set at least one campaign parameter"})
+	}
+	if len(fieldErrors) > 0 {
+		respondValidationError(w, fieldErrors)
+		return
+	}
+
+	var exists bool
+	err = updateUTMPresets(func(presets map[string]UTMParams) error {
+		_, exists = presets[name]
+		if !exists && len(presets) >= maxUTMPresets {
+			return errTooManyUTMPresets
+		}
+		presets[name] = params
+		return nil
+	})
+	if err == errTooManyUTMPresets {
+		respondError(w, http.StatusConflict, fmt.Sprintf("No more than %d UTM presets are allowed", maxUTMPresets))
+		return
+	} else if err != nil {
+		log.Printf("[Shortener Service] Failed to save UTM presets: %v\n", err)
+		respondError(w, http.StatusInternalServerError, "Failed to save UTM preset")
+		return
+	}
+
+	log.Printf("[Shortener Service] Saved UTM preset '%s'\n", name)
+	status := http.StatusOK
+	if !exists {
+		status = http.StatusCreated
+	}
+	respondJSON(w, status, UTMPreset{Name: name, UTMParams: params})
+}
+
+func deleteUTMPresetHandler(w http.ResponseWriter, r *http.Request) {
+	name, err := utmPresetName(r)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "UTM preset not found")
+		return
+	}
+
+	err = updateUTMPresets(func(presets map[string]UTMParams) error {
+		if _, ok := presets[name]; !ok {
+			return errUTMPresetNotFound
+		}
+		delete(presets, name)
+		return nil
+	})
+	if err == errUTMPresetNotFound {
+		respondError(w, http.StatusNotFound, "UTM preset not found")
+		return
+	} else if err != nil {
+		log.Printf("[Shortener Service] Failed to save UTM presets: %v\n", err)
+		respondError(w, http.StatusInternalServerError, "Failed to delete UTM preset")
+		return
+	}
+
+	log.Printf("[Shortener Service] Deleted UTM preset '%s'\n", name)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/itcaat/url-shortener-demo/pkg/linkstore"
+	"golang.org/x/net/idna"
+)
+
+const (
+	maxURLLength = 2048
+	maxTags      = 20
+	maxTagLength = 32
+
+	maxTitleLength = 200
+
+	minPasswordLength = 4
+	maxPasswordLength = 128
+
+	maxClicksLimit = 1_000_000_000
+)
+
+var (
+	// Разрешённые схемы целевых URL
+	allowedSchemes = parseList(getEnv("ALLOWED_URL_SCHEMES", "http,https"))
+
+	// Хосты redirect-service: ссылки на них создали бы петлю перенаправлений
+	redirectHosts = parseList(getEnv("REDIRECT_HOSTS", "localhost:3002"))
+)
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type ValidationErrorResponse struct {
+	Error  string       `json:"error"`
+	Fields []FieldError `json:"fields"`
+}
+
+// normalizeURL проверяет целевой URL и приводит его к каноническому виду:
+// схема и хост в нижнем регистре, IDN в punycode, без порта по умолчанию, пустой путь заменяется на "/"
+func normalizeURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", errors.New("URL is required")
+	}
+	if len(raw) > maxURLLength {
+		return "", fmt.Errorf("URL must not exceed %d characters", maxURLLength)
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", errors.New("URL is malformed")
+	}
+	if !u.IsAbs() {
+		return "", errors.New("URL must be absolute, e.g. https://example.com/page")
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if !contains(allowedSchemes, u.Scheme) {
+		return "", fmt.Errorf("URL scheme '%s' is not allowed (allowed: %s)", u.Scheme, strings.Join(allowedSchemes, ", "))
+	}
+	if u.Host == "" {
+		return "", errors.New("URL must include a host")
+	}
+	if u.User != nil {
+		return "", errors.New("URL must not contain credentials")
+	}
+
+	host, err := normalizeHost(u.Hostname())
+	if err != nil {
+		return "", err
+	}
+	port := u.Port()
+	if port == defaultPorts[u.Scheme] {
+		port = ""
+	}
+	if port != "" {
+		u.Host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		u.Host = "[" + host + "]"
+	} else {
+		u.Host = host
+	}
+
+	effectivePort := port
+	if effectivePort == "" {
+		effectivePort = defaultPorts[u.Scheme]
+	}
+	if isRedirectHost(host, effectivePort) {
+		return "", errors.New("URL must not point to the short link domain")
+	}
+
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	return u.String(), nil
+}
+
+// normalizeHost приводит хост к нижнему регистру и конвертирует IDN в punycode
+func normalizeHost(host string) (string, error) {
+	if host == "" {
+		return "", errors.New("URL host is empty")
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+	ascii, err := idna.Lookup.ToASCII(strings.TrimSuffix(host, "."))
+	if err != nil {
+		return "", fmt.Errorf("URL host '%s' is invalid", host)
+	}
+	return ascii, nil
+}
+
+// isRedirectHost проверяет, указывают ли хост и порт (с учётом порта схемы по умолчанию) на один из
+// наших redirect-доменов. Запись REDIRECT_HOSTS без порта совпадает с любым портом хоста.
+func isRedirectHost(host, port string) bool {
+	for _, h := range redirectHosts {
+		rh, rp, err := net.SplitHostPort(h)
+		if err != nil {
+			rh, rp = h, ""
+		}
+		if strings.EqualFold(rh, host) && (rp == "" || rp == port) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeTags приводит теги к нижнему регистру, убирает пустые и повторяющиеся
+func normalizeTags(tags []string) ([]string, error) {
+	normalized := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > maxTagLength || strings.ContainsAny(tag, ",:/ ") {
+			return nil, fmt.Errorf("tag '%s' is invalid: up to %d characters without spaces, ',', ':' or '/'", tag, maxTagLength)
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) > maxTags {
+		return nil, fmt.Errorf("no more than %d tags are allowed", maxTags)
+	}
+	return normalized, nil
+}
+
+// normalizeTitle обрезает пробелы и проверяет длину заголовка
+func normalizeTitle(title string) (string, error) {
+	title = strings.TrimSpace(title)
+	if utf8.RuneCountInString(title) > maxTitleLength {
+		return "", fmt.Errorf("title must not exceed %d characters", maxTitleLength)
+	}
+	return title, nil
+}
+
+// validatePassword проверяет длину пароля ссылки
+func validatePassword(password string) error {
+	if n := utf8.RuneCountInString(password); n < minPasswordLength || n > maxPasswordLength {
+		return fmt.Errorf("password must be between %d and %d characters long", minPasswordLength, maxPasswordLength)
+	}
+	return nil
+}
+
+// validateMaxClicks проверяет лимит переходов; 0 - без ограничения
+func validateMaxClicks(maxClicks int64) error {
+	if maxClicks < 0 || maxClicks > maxClicksLimit {
+		return fmt.Errorf("maxClicks must be between 0 and %d", maxClicksLimit)
+	}
+	return nil
+}
+
+// validateQueryPassthrough проверяет политику передачи параметров запроса; пустая - не передавать
+func validateQueryPassthrough(policy string) error {
+	switch policy {
+	case "", linkstore.QueryMerge, linkstore.QueryOverride:
+		return nil
+	default:
+		return fmt.Errorf("queryPassthrough must be '%s' or '%s'", linkstore.QueryMerge, linkstore.QueryOverride)
+	}
+}
+
+// validateRedirectStatus проверяет код перенаправления ссылки; 0 - код по умолчанию redirect-service
+func validateRedirectStatus(status int) error {
+	if status == 0 || slices.Contains(linkstore.RedirectStatuses, status) {
+		return nil
+	}
+	return errors.New("redirectStatus must be 301, 302, 307 or 308")
+}
+
+// validateSchedule проверяет окно активности ссылки: оно должно начинаться раньше, чем закончится
+// само окно и срок действия, а запасной адрес нужен только до начала окна
+func validateSchedule(activeFrom, activeUntil, expiresAt *time.Time, fallbackURL string) []FieldError {
+	var fieldErrors []FieldError
+	if activeFrom != nil && activeUntil != nil && !activeUntil.After(*activeFrom) {
+		fieldErrors = append(fieldErrors, FieldError{Field: "activeUntil", Message: "activeUntil must be after activeFrom"})
+	}
+	if activeFrom != nil && expiresAt != nil && !expiresAt.After(*activeFrom) {
+		fieldErrors = append(fieldErrors, FieldError{Field: "activeFrom", Message: "activeFrom must be before expiresAt"})
+	}
+	if fallbackURL != "" && activeFrom == nil {
+		fieldErrors = append(fieldErrors, FieldError{Field: "fallbackUrl", Message: "fallbackUrl requires activeFrom"})
+	}
+	return fieldErrors
+}
+
+// scheduleTime приводит границу окна активности к UTC с точностью до секунды
+func scheduleTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	truncated := t.UTC().Truncate(time.Second)
+	return &truncated
+}
+
+func respondValidationError(w http.ResponseWriter, fields []FieldError) {
+	respondJSON(w, http.StatusBadRequest, ValidationErrorResponse{
+		Error:  "Validation failed",
+		Fields: fields,
+	})
+}
+
+func parseList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func contains(items []string, value string) bool {
+	for _, item := range items {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}